@@ -0,0 +1,261 @@
+// Package chaincli implements the "chain" command, which inspects and
+// maintains the share chain stored in the data directory.
+package chaincli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/p2pool-go/config"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(c *cli, args []string) error
+}
+
+var commands = []command{
+	{"info", "", "Show the height, tip, tail and total work of the chain", (*cli).info},
+	{"show", "<hash>", "Show a share as JSON", (*cli).show},
+	{"verify", "", "Verify every share in the chain", (*cli).verify},
+	{"export", "[-json|-csv]", "Write all shares from tail to tip to stdout", (*cli).export},
+	{"payouts", "[hash]", "Show the payouts if the share (default the tip) found a block", (*cli).payouts},
+	{"import", "<path>", "Add the shares in a share chain file, or a data/<net> directory or shares.N file of the Python p2pool, to the chain", (*cli).importShares},
+}
+
+type cli struct {
+	cfg     *config.Config
+	network p2pnet.Network
+	out     io.Writer
+	chain   *work.ShareChain
+}
+
+// Run executes the chain subcommand given in args on the share chain of the
+// network configured in cfg. Output is written to out.
+func Run(cfg *config.Config, n p2pnet.Network, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		printUsage(out)
+		return fmt.Errorf("No chain command given")
+	}
+	c := &cli{cfg: cfg, network: n, out: out}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(c, args[1:])
+		}
+	}
+	printUsage(out)
+	return fmt.Errorf("Unknown chain command %s", args[0])
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: p2pool-go [flags] chain <command> [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %-14s %s\n", cmd.name, cmd.args, cmd.usage)
+	}
+}
+
+func (c *cli) path() string {
+	return filepath.Join(c.cfg.NetworkDataDir(), work.ShareChainFile)
+}
+
+// load reads the share chain from the data directory. The chain is never
+// written back unless a command changes it.
+func (c *cli) load() error {
+	// The context is never cancelled, so the chain does not commit itself
+	// when the command exits.
+	c.chain = work.NewShareChain(context.Background(), c.network, c.cfg.NetworkDataDir())
+	return c.chain.Load()
+}
+
+// readShares reads the shares in the share chain file without connecting
+// them, so invalid shares can be reported instead of failing the load.
+func (c *cli) readShares() ([]wire.Share, error) {
+	f, err := os.Open(c.path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return wire.ReadShares(f, c.network)
+}
+
+// mainChain returns the shares from the tail to the tip
+func (c *cli) mainChain() []*wire.Share {
+	shares := c.chain.GetRecentShares(c.chain.GetShareCount())
+	for i, j := 0, len(shares)-1; i < j; i, j = i+1, j-1 {
+		shares[i], shares[j] = shares[j], shares[i]
+	}
+	return shares
+}
+
+func (c *cli) info(args []string) error {
+	err := c.load()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Network:      %s\n", c.network.Name)
+	fmt.Fprintf(c.out, "File:         %s\n", c.path())
+	shares := c.mainChain()
+	if len(shares) == 0 {
+		fmt.Fprintf(c.out, "Height:       0\n")
+		return nil
+	}
+
+	tip, tail := shares[len(shares)-1], shares[0]
+	totalWork := big.NewInt(0)
+	for _, s := range shares {
+		totalWork.Add(totalWork, work.TargetToAverageAttempts(blockchain.CompactToBig(uint32(s.ShareInfo.Bits))))
+	}
+
+	fmt.Fprintf(c.out, "Height:       %d (%d shares stored)\n", len(shares), c.chain.GetShareCount())
+	fmt.Fprintf(c.out, "Tip:          %s (absolute height %d)\n", tip.Hash.String(), tip.ShareInfo.AbsHeight)
+	fmt.Fprintf(c.out, "Tail:         %s (absolute height %d)\n", tail.Hash.String(), tail.ShareInfo.AbsHeight)
+	fmt.Fprintf(c.out, "Work:         %s hashes\n", totalWork.String())
+	fmt.Fprintf(c.out, "Tip abs work: %s hashes\n", tip.ShareInfo.AbsWork.String())
+	return nil
+}
+
+func (c *cli) show(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: chain show <hash>")
+	}
+	hash, err := chainhash.NewHashFromStr(args[0])
+	if err != nil {
+		return err
+	}
+	err = c.load()
+	if err != nil {
+		return err
+	}
+	s := c.chain.GetShare(hash)
+	if s == nil {
+		return fmt.Errorf("Unknown share %s", hash.String())
+	}
+
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(wire.JSONValue(s))
+}
+
+func (c *cli) verify(args []string) error {
+	shares, err := c.readShares()
+	if err != nil {
+		return err
+	}
+
+	errs := work.VerifyShares(c.network, shares)
+	for _, err := range errs {
+		fmt.Fprintln(c.out, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problems found in %d shares", len(errs), len(shares))
+	}
+	fmt.Fprintf(c.out, "%d shares verified\n", len(shares))
+	return nil
+}
+
+func (c *cli) payouts(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Usage: chain payouts [hash]")
+	}
+	err := c.load()
+	if err != nil {
+		return err
+	}
+	hash := c.chain.GetTipHash()
+	if len(args) == 1 {
+		hash, err = chainhash.NewHashFromStr(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	if hash == nil {
+		return fmt.Errorf("Share chain is empty")
+	}
+
+	p, err := c.chain.GetPayouts(hash)
+	if err != nil {
+		return err
+	}
+	addrs := make([]string, 0, len(p.Amounts))
+	for a := range p.Amounts {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if p.Amounts[addrs[i]] == p.Amounts[addrs[j]] {
+			return addrs[i] < addrs[j]
+		}
+		return p.Amounts[addrs[i]] > p.Amounts[addrs[j]]
+	})
+	for _, a := range addrs {
+		fmt.Fprintf(c.out, "%-42s %.8f %s\n", a, float64(p.Amounts[a])/1e8, c.network.Symbol)
+	}
+	fmt.Fprintf(c.out, "%-42s %.8f %s\n", "donation", float64(p.Donation)/1e8, c.network.Symbol)
+	return nil
+}
+
+func (c *cli) importShares(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: chain import <path>")
+	}
+	shares, err := c.readImport(args[0])
+	if err != nil {
+		return err
+	}
+
+	valid := make([]wire.Share, 0, len(shares))
+	for i := range shares {
+		err = work.VerifyShare(c.network, &shares[i])
+		if err != nil {
+			fmt.Fprintf(c.out, "Skipping %s\n", err.Error())
+			continue
+		}
+		valid = append(valid, shares[i])
+	}
+
+	err = c.load()
+	if err != nil {
+		return err
+	}
+	before := c.chain.GetShareCount()
+	c.chain.AddShares(valid)
+	fmt.Fprintf(c.out, "Imported %d of %d shares, the chain now holds %d shares\n", c.chain.GetShareCount()-before, len(shares), c.chain.GetShareCount())
+	return nil
+}
+
+// readImport reads the shares to import from path, which is either a share
+// chain file of p2pool-go or the share store of the reference
+// implementation.
+func (c *cli) readImport(path string) ([]wire.Share, error) {
+	if work.IsPythonShareStore(path) {
+		res, err := work.ReadPythonShareStore(path, c.network)
+		if err != nil {
+			return nil, err
+		}
+		for _, err := range res.Skipped {
+			fmt.Fprintf(c.out, "Skipping %s\n", err.Error())
+		}
+		fmt.Fprintf(c.out, "Read %d shares from %d files of the reference implementation\n", len(res.Shares), res.Files)
+		return res.Shares, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return wire.ReadShares(f, c.network)
+}
@@ -0,0 +1,71 @@
+package chaincli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+var csvHeader = []string{"hash", "previous_hash", "abs_height", "timestamp", "version", "address", "difficulty", "stale_info", "pow_hash", "is_block"}
+
+func (c *cli) export(args []string) error {
+	fs := flag.NewFlagSet("chain export", flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	asJSON := fs.Bool("json", false, "Write one JSON object per share, with all its fields")
+	asCSV := fs.Bool("csv", false, "Write a CSV table with one row per share (default)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *asJSON && *asCSV {
+		return fmt.Errorf("Choose either -json or -csv")
+	}
+
+	err = c.load()
+	if err != nil {
+		return err
+	}
+	shares := c.mainChain()
+
+	if *asJSON {
+		enc := json.NewEncoder(c.out)
+		for _, s := range shares {
+			err = enc.Encode(wire.JSONValue(s))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := csv.NewWriter(c.out)
+	err = w.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, s := range shares {
+		err = w.Write([]string{
+			s.Hash.String(),
+			s.ShareInfo.ShareData.PreviousShareHash.String(),
+			strconv.Itoa(int(s.ShareInfo.AbsHeight)),
+			strconv.Itoa(int(s.ShareInfo.Timestamp)),
+			strconv.FormatUint(s.Type, 10),
+			work.ShareAddress(s),
+			strconv.FormatFloat(work.TargetToDifficulty(blockchain.CompactToBig(uint32(s.ShareInfo.Bits))), 'f', -1, 64),
+			s.ShareInfo.ShareData.StaleInfo.String(),
+			s.POWHash.String(),
+			strconv.FormatBool(s.IsBlock()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
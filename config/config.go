@@ -0,0 +1,355 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/nat"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is prepended to the upper-cased flag name to form the environment
+// variable that overrides a setting, e.g. P2POOL_RPC_USER for --rpc-user.
+const EnvPrefix = "P2POOL_"
+
+const defaultConfigFile = "p2pool.yaml"
+
+type Config struct {
+	Network        string        `yaml:"network"`
+	DataDir        string        `yaml:"datadir"`
+	LogLevel       string        `yaml:"log-level"`
+	LogFormat      string        `yaml:"log-format"`
+	LogFile        string        `yaml:"log-file"`
+	LogMaxSize     int           `yaml:"log-max-size"`
+	LogMaxFiles    int           `yaml:"log-max-files"`
+	P2PPort        int           `yaml:"p2p-port"`
+	WebPort        int           `yaml:"web-port"`
+	AdminPort      int           `yaml:"admin-port"`
+	RPCHost        string        `yaml:"rpc-host"`
+	RPCUser        string        `yaml:"rpc-user"`
+	RPCPassword    string        `yaml:"rpc-password"`
+	Fee            float64       `yaml:"fee"`
+	MinPeers       int           `yaml:"min-peers"`
+	MaxPeers       int           `yaml:"max-peers"`
+	CaptureDir     string        `yaml:"capture-dir"`
+	ExternalIP     string        `yaml:"external-ip"`
+	NAT            string        `yaml:"nat"`
+	PingInterval   time.Duration `yaml:"ping-interval"`
+	IdlePings      int           `yaml:"idle-pings"`
+	WriteTimeout   time.Duration `yaml:"write-timeout"`
+	SendQueueSize  int           `yaml:"send-queue-size"`
+	Peers          string        `yaml:"peers"`
+	Proxy          string        `yaml:"proxy"`
+	ProxyOnly      bool          `yaml:"proxy-only"`
+	StaleWindow    time.Duration `yaml:"stale-window"`
+	HashRateWindow time.Duration `yaml:"hashrate-window"`
+
+	// Set from the command line only
+	ConfigFile  string   `yaml:"-"`
+	PrintConfig bool     `yaml:"-"`
+	Args        []string `yaml:"-"` // Arguments left after the flags
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() Config {
+	dataDir := ".p2pool-go"
+	home, err := os.UserHomeDir()
+	if err == nil {
+		dataDir = filepath.Join(home, dataDir)
+	}
+	return Config{
+		Network:        "vertcoin",
+		DataDir:        dataDir,
+		LogLevel:       "info",
+		LogFormat:      "logfmt",
+		LogFile:        "p2pool.log",
+		LogMaxSize:     10,
+		LogMaxFiles:    5,
+		WebPort:        9172,
+		AdminPort:      9173,
+		RPCHost:        "127.0.0.1:5888",
+		MinPeers:       1,
+		MaxPeers:       20,
+		NAT:            "none",
+		PingInterval:   15 * time.Second,
+		IdlePings:      8,
+		WriteTimeout:   30 * time.Second,
+		SendQueueSize:  16,
+		StaleWindow:    time.Hour,
+		HashRateWindow: time.Hour,
+	}
+}
+
+func flagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("p2pool-go", flag.ContinueOnError)
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "Path to the configuration file (default <datadir>/"+defaultConfigFile+")")
+	fs.BoolVar(&c.PrintConfig, "print-config", c.PrintConfig, "Print the effective configuration and exit")
+	fs.StringVar(&c.Network, "network", c.Network, "Network to run on ("+strings.Join(p2pnet.Names(), ", ")+")")
+	fs.StringVar(&c.DataDir, "datadir", c.DataDir, "Directory to store the sharechain and address book in")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Log level (debug, info, warn, error), optionally followed by levels per subsystem, e.g. info,wire=debug,p2p=warn")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Log format (logfmt or json)")
+	fs.StringVar(&c.LogFile, "log-file", c.LogFile, "Log file, relative to the network data directory (empty disables logging to a file)")
+	fs.IntVar(&c.LogMaxSize, "log-max-size", c.LogMaxSize, "Size in MB at which the log file is rotated")
+	fs.IntVar(&c.LogMaxFiles, "log-max-files", c.LogMaxFiles, "Number of rotated log files to keep")
+	fs.IntVar(&c.P2PPort, "p2p-port", c.P2PPort, "Port for p2pool peer connections (0 uses the network default)")
+	fs.IntVar(&c.WebPort, "web-port", c.WebPort, "Port for the web interface (0 disables it)")
+	fs.IntVar(&c.AdminPort, "admin-port", c.AdminPort, "Port on 127.0.0.1 for the admin API (0 disables it)")
+	fs.StringVar(&c.RPCHost, "rpc-host", c.RPCHost, "host:port of the fullnode RPC interface")
+	fs.StringVar(&c.RPCUser, "rpc-user", c.RPCUser, "Username for the fullnode RPC interface")
+	fs.StringVar(&c.RPCPassword, "rpc-password", c.RPCPassword, "Password for the fullnode RPC interface")
+	fs.Float64Var(&c.Fee, "fee", c.Fee, "Percentage fee charged to miners that mine to their own address")
+	fs.IntVar(&c.MinPeers, "min-peers", c.MinPeers, "Number of outbound peers to keep connected")
+	fs.IntVar(&c.MaxPeers, "max-peers", c.MaxPeers, "Maximum number of peers")
+	fs.StringVar(&c.CaptureDir, "capture-dir", c.CaptureDir, "Directory to record all peer traffic to for replaying, relative to the network data directory (empty disables recording)")
+	fs.StringVar(&c.ExternalIP, "external-ip", c.ExternalIP, "Public IP address to advertise to peers (default: the address the router reports, or the address most peers see this node at)")
+	fs.StringVar(&c.NAT, "nat", c.NAT, "Map the p2p port on the router: none, auto, upnp[:<device URL>] or pmp[:<gateway>]")
+	fs.DurationVar(&c.PingInterval, "ping-interval", c.PingInterval, "Interval at which peers are pinged")
+	fs.IntVar(&c.IdlePings, "idle-pings", c.IdlePings, "Number of ping intervals without any message from a peer after which it is disconnected")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "Time a peer gets to accept a message before it is disconnected as stalled")
+	fs.IntVar(&c.SendQueueSize, "send-queue-size", c.SendQueueSize, "Size in MB of the messages queued for a single peer before it is disconnected as stalled")
+	fs.StringVar(&c.Peers, "peers", c.Peers, "Comma separated host:port list of peers to connect to, which may include .onion addresses")
+	fs.StringVar(&c.Proxy, "proxy", c.Proxy, "host:port of a SOCKS5 proxy, such as Tor, to connect to .onion peers through")
+	fs.BoolVar(&c.ProxyOnly, "proxy-only", c.ProxyOnly, "Connect to all peers through the proxy and do not advertise this node's address")
+	fs.DurationVar(&c.StaleWindow, "stale-window", c.StaleWindow, "Period of the share chain stale rates are calculated over")
+	fs.DurationVar(&c.HashRateWindow, "hashrate-window", c.HashRateWindow, "Period of the share chain hashrates are estimated over")
+	return fs
+}
+
+// Load builds the effective configuration from, in increasing order of
+// precedence, the defaults, the configuration file, environment variables and
+// the command line arguments in args.
+func Load(args []string) (*Config, error) {
+	// First pass only to find out where the configuration file lives
+	c := Default()
+	err := flagSet(&c).Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	explicitFile := c.ConfigFile != ""
+	file := c.ConfigFile
+	if !explicitFile {
+		file = filepath.Join(c.DataDir, defaultConfigFile)
+	}
+
+	c = Default()
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = yaml.UnmarshalStrict(b, &c)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse config file %s: %s", file, err.Error())
+		}
+	} else if explicitFile || !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	fs := flagSet(&c)
+	err = applyEnv(fs)
+	if err != nil {
+		return nil, err
+	}
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	c.ConfigFile = file
+	c.Args = fs.Args()
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		v, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(v); setErr != nil {
+			err = fmt.Errorf("Invalid value for %s: %s", name, setErr.Error())
+		}
+	})
+	return err
+}
+
+// Validate checks the configuration for values that cannot work.
+func (c *Config) Validate() error {
+	_, err := p2pnet.ByName(c.Network)
+	if err != nil {
+		return err
+	}
+	if c.DataDir == "" {
+		return fmt.Errorf("No data directory configured")
+	}
+	_, _, err = logging.ParseLevels(c.LogLevel)
+	if err != nil {
+		return err
+	}
+	_, err = logging.ParseFormat(c.LogFormat)
+	if err != nil {
+		return err
+	}
+	if c.LogMaxSize < 1 {
+		return fmt.Errorf("log-max-size must be at least 1")
+	}
+	if c.LogMaxFiles < 0 {
+		return fmt.Errorf("log-max-files cannot be negative")
+	}
+	if c.P2PPort < 0 || c.P2PPort > 65535 {
+		return fmt.Errorf("Invalid p2p port %d", c.P2PPort)
+	}
+	if c.WebPort < 0 || c.WebPort > 65535 {
+		return fmt.Errorf("Invalid web port %d", c.WebPort)
+	}
+	if c.AdminPort < 0 || c.AdminPort > 65535 {
+		return fmt.Errorf("Invalid admin port %d", c.AdminPort)
+	}
+	if c.AdminPort != 0 && c.AdminPort == c.WebPort {
+		return fmt.Errorf("admin-port must differ from web-port")
+	}
+	if c.Fee < 0 || c.Fee > 100 {
+		return fmt.Errorf("Fee must be between 0 and 100 percent")
+	}
+	if (c.RPCUser == "") != (c.RPCPassword == "") {
+		return fmt.Errorf("RPC user and password must be set together")
+	}
+	if c.MinPeers < 1 {
+		return fmt.Errorf("min-peers must be at least 1")
+	}
+	if c.MaxPeers < c.MinPeers {
+		return fmt.Errorf("max-peers (%d) cannot be lower than min-peers (%d)", c.MaxPeers, c.MinPeers)
+	}
+	if c.ExternalIP != "" && net.ParseIP(c.ExternalIP) == nil {
+		return fmt.Errorf("Invalid external IP %s", c.ExternalIP)
+	}
+	err = nat.CheckSpec(c.NAT)
+	if err != nil {
+		return err
+	}
+	if c.PingInterval <= 0 {
+		return fmt.Errorf("ping-interval must be positive")
+	}
+	if c.IdlePings < 1 {
+		return fmt.Errorf("idle-pings must be at least 1")
+	}
+	if c.WriteTimeout <= 0 {
+		return fmt.Errorf("write-timeout must be positive")
+	}
+	if c.SendQueueSize < 1 {
+		return fmt.Errorf("send-queue-size must be at least 1")
+	}
+	if c.StaleWindow <= 0 {
+		return fmt.Errorf("stale-window must be positive")
+	}
+	if c.HashRateWindow <= 0 {
+		return fmt.Errorf("hashrate-window must be positive")
+	}
+	if c.Proxy != "" {
+		_, err = checkHostPort(c.Proxy)
+		if err != nil {
+			return fmt.Errorf("Invalid proxy: %s", err.Error())
+		}
+	}
+	if c.ProxyOnly {
+		if c.Proxy == "" {
+			return fmt.Errorf("proxy-only needs a proxy")
+		}
+		if c.NAT != "none" {
+			return fmt.Errorf("nat must be none with proxy-only, mapping ports would reveal this node's address")
+		}
+	}
+	for _, peer := range c.PeerList() {
+		host, err := checkHostPort(peer)
+		if err != nil {
+			return fmt.Errorf("Invalid peer: %s", err.Error())
+		}
+		if c.Proxy == "" && wire.IsOnion(host) {
+			return fmt.Errorf("Cannot connect to peer %s without a proxy", peer)
+		}
+	}
+	return nil
+}
+
+// checkHostPort checks that addr is a host:port pair and returns the host
+func checkHostPort(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", fmt.Errorf("No host in %s", addr)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("Invalid port in %s", addr)
+	}
+	return host, nil
+}
+
+// PeerList returns the host:port addresses of the configured peers
+func (c *Config) PeerList() []string {
+	peers := make([]string, 0)
+	for _, p := range strings.Split(c.Peers, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// NetworkDataDir is the directory that holds the data for the configured
+// network, so multiple networks can share the same data directory.
+func (c *Config) NetworkDataDir() string {
+	return filepath.Join(c.DataDir, c.Network)
+}
+
+// LogFilePath returns the path of the log file, or an empty string when
+// logging to a file is disabled.
+func (c *Config) LogFilePath() string {
+	return c.networkPath(c.LogFile)
+}
+
+// CaptureDirPath returns the directory peer traffic is recorded to, or an
+// empty string when recording is disabled.
+func (c *Config) CaptureDirPath() string {
+	return c.networkPath(c.CaptureDir)
+}
+
+func (c *Config) networkPath(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.NetworkDataDir(), p)
+}
+
+// Print writes the configuration to w in the configuration file format, with
+// the RPC password masked.
+func (c *Config) Print(w io.Writer) error {
+	p := *c
+	if p.RPCPassword != "" {
+		p.RPCPassword = "********"
+	}
+	b, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# Configuration file: %s\n", c.ConfigFile)
+	_, err = w.Write(b)
+	return err
+}
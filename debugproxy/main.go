@@ -1,86 +1,225 @@
+// Command debugproxy sits between a p2pool node and a peer, forwards all
+// traffic and logs every message decoded as JSON, one line per message.
 package main
 
 import (
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/logging"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+const (
+	clientToServer = "client-to-server"
+	serverToClient = "server-to-client"
 )
 
+type record struct {
+	Time      string      `json:"time"`
+	Session   int         `json:"session"`
+	Direction string      `json:"direction"`
+	Command   string      `json:"command,omitempty"`
+	Length    int         `json:"length"`
+	Message   interface{} `json:"message,omitempty"`
+	Payload   string      `json:"payload,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type proxy struct {
+	network    p2pnet.Network
+	remote     string
+	captureDir string
+
+	outLock sync.Mutex
+	out     *json.Encoder
+}
+
 func main() {
 	listen := flag.Int("listen", 0, "The local port to listen on")
-	remote := flag.String("remote", "", "The remote host to connect to")
+	remote := flag.String("remote", "", "The remote host:port to connect to")
+	network := flag.String("network", "vertcoin", "Network to decode messages for ("+strings.Join(p2pnet.Names(), ", ")+")")
+	outFile := flag.String("out", "", "File to write decoded messages to (default stdout)")
+	captureDir := flag.String("capture", "", "Directory to write a capture file per session to, for use with the replay command")
+	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 	flag.Parse()
 
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *listen))
+	err := logging.SetLevels(*logLevel)
+	if err != nil {
+		fail(err)
+	}
+	if *remote == "" {
+		fail(fmt.Errorf("No remote given"))
+	}
+	n, err := p2pnet.ByName(*network)
 	if err != nil {
-		panic(err)
+		fail(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outFile != "" {
+		f, err := os.OpenFile(*outFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		out = f
 	}
 
-	fmt.Printf("Waiting for client")
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
-	client, err := l.Accept()
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "tcp", fmt.Sprintf(":%d", *listen))
 	if err != nil {
-		panic(err)
+		fail(err)
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	logging.Infof("Listening on %s, forwarding to %s", l.Addr().String(), *remote)
+
+	p := &proxy{network: n, remote: *remote, captureDir: *captureDir, out: json.NewEncoder(out)}
+	var wg sync.WaitGroup
+	for session := 1; ; session++ {
+		client, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Errorf("Error accepting client: %s", err.Error())
+			}
+			break
+		}
+		wg.Add(1)
+		go func(session int) {
+			defer wg.Done()
+			p.serve(ctx, session, client)
+		}(session)
 	}
+	wg.Wait()
+}
 
-	fmt.Printf("Connecing to remote")
+// serve connects client to the remote and forwards messages both ways until
+// either side disconnects.
+func (p *proxy) serve(ctx context.Context, session int, client net.Conn) {
+	logging.Infof("Session %d: client %s connected", session, client.RemoteAddr().String())
 
-	conn, err := net.Dial("tcp", *remote)
+	d := net.Dialer{Timeout: 10 * time.Second}
+	server, err := d.DialContext(ctx, "tcp", p.remote)
 	if err != nil {
-		panic(err)
+		logging.Errorf("Session %d: could not connect to %s: %s", session, p.remote, err.Error())
+		client.Close()
+		return
 	}
 
-	c2s, err := os.Create("client-to-server.log")
-	defer c2s.Close()
-	s2c, err := os.Create("server-to-client.log")
-	defer s2c.Close()
+	// Captures are recorded from the client's point of view, so replaying
+	// one feeds the server's messages to a node again.
+	var capture *wire.CaptureWriter
+	if p.captureDir != "" {
+		capture, err = wire.CreateCapture(p.captureDir, client.RemoteAddr().String(), p.network)
+		if err != nil {
+			logging.Errorf("Session %d: could not create capture file: %s", session, err.Error())
+		} else {
+			defer capture.Close()
+		}
+	}
 
+	closeBoth := func() {
+		client.Close()
+		server.Close()
+	}
+	done := make(chan struct{})
 	go func() {
-		b := make([]byte, 1)
-		for {
-			i, err := client.Read(b)
-			if err != nil {
-				panic(err)
-			}
-			i2, err := conn.Write(b)
-			if err != nil {
-				panic(err)
-			}
-			if i != i2 {
-				panic("Did not forward all bytes")
-			}
-			i3, err := c2s.Write([]byte(hex.EncodeToString(b)))
-			if err != nil {
-				panic(err)
-			}
-			if i2*2 != i3 {
-				panic("Did not log all bytes")
-			}
+		select {
+		case <-ctx.Done():
+			closeBoth()
+		case <-done:
 		}
 	}()
 
-	b := make([]byte, 1)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.forward(session, clientToServer, client, server, capture)
+		closeBoth()
+	}()
+	go func() {
+		defer wg.Done()
+		p.forward(session, serverToClient, server, client, capture)
+		closeBoth()
+	}()
+	wg.Wait()
+	close(done)
+
+	logging.Infof("Session %d: closed", session)
+}
+
+// forward copies messages from one side to the other, logging each of them,
+// until reading or writing fails.
+func (p *proxy) forward(session int, direction string, from, to net.Conn, capture *wire.CaptureWriter) {
 	for {
-		i, err := conn.Read(b)
+		raw, err := wire.ReadRawMessage(from, p.network)
 		if err != nil {
-			panic(err)
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				p.write(record{Session: session, Direction: direction, Error: err.Error()})
+			}
+			return
 		}
-		i2, err := client.Write(b)
+
+		err = wire.WriteRawMessage(to, p.network, raw)
 		if err != nil {
-			panic(err)
+			logging.Warnf("Session %d: could not forward [%s] message: %s", session, raw.Command, err.Error())
+			return
 		}
-		if i != i2 {
-			panic("Did not forward all bytes")
+
+		if capture != nil {
+			dir := wire.CaptureReceived
+			if direction == clientToServer {
+				dir = wire.CaptureSent
+			}
+			err = capture.Write(wire.CaptureRecord{Time: time.Now(), Direction: dir, Message: raw})
+			if err != nil {
+				logging.Warnf("Session %d: could not write capture: %s", session, err.Error())
+			}
 		}
-		i3, err := s2c.Write([]byte(hex.EncodeToString(b)))
+
+		r := record{Session: session, Direction: direction, Command: raw.Command, Length: len(raw.Payload)}
+		msg, err := wire.ParseMessage(p.network, raw.Command, raw.Payload)
 		if err != nil {
-			panic(err)
-		}
-		if i2*2 != i3 {
-			panic("Did not log all bytes")
+			r.Error = err.Error()
+			r.Payload = hex.EncodeToString(raw.Payload)
+		} else {
+			r.Message = wire.JSONValue(msg)
 		}
+		p.write(r)
+	}
+}
+
+func (p *proxy) write(r record) {
+	r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	p.outLock.Lock()
+	defer p.outLock.Unlock()
+	err := p.out.Encode(r)
+	if err != nil {
+		logging.Errorf("Could not write message log: %s", err.Error())
 	}
+}
 
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
 }
@@ -0,0 +1,48 @@
+package http
+
+import (
+	nethttp "net/http"
+
+	"github.com/gertjaap/p2pool-go/logging"
+)
+
+// registerAdmin registers the endpoints that change the node at runtime.
+// They are served by StartAdmin on the loopback interface only.
+func (s *Server) registerAdmin() {
+	s.adminMux.Handle("/admin/log_level", jsonHandler(s.logLevel))
+}
+
+type logLevels struct {
+	Default    string            `json:"default"`
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+// logLevel returns the current log levels. A POST with a level, and
+// optionally a subsystem, changes the level of that subsystem or the default
+// level.
+func (s *Server) logLevel(r *nethttp.Request) (interface{}, error) {
+	switch r.Method {
+	case nethttp.MethodGet:
+	case nethttp.MethodPost, nethttp.MethodPut:
+		level, err := logging.ParseLogLevel(r.FormValue("level"))
+		if err != nil {
+			return nil, &statusError{nethttp.StatusBadRequest, err.Error()}
+		}
+		subsystem := r.FormValue("subsystem")
+		if subsystem == "" {
+			logging.SetLogLevel(int(level))
+		} else {
+			logging.SetSubsystemLevel(subsystem, level)
+		}
+		log.Info("Changed log level", "subsystem", subsystem, "level", level)
+	default:
+		return nil, &statusError{nethttp.StatusMethodNotAllowed, "Method not allowed"}
+	}
+
+	def, levels := logging.Levels()
+	res := logLevels{Default: def.String(), Subsystems: map[string]string{}}
+	for k, v := range levels {
+		res.Subsystems[k] = v.String()
+	}
+	return res, nil
+}
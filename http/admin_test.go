@@ -0,0 +1,60 @@
+package http
+
+import (
+	"context"
+	"net"
+	nethttp "net/http"
+	"testing"
+
+	"github.com/gertjaap/p2pool-go/config"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/p2p"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+func TestAdminOnlyOnLoopbackListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	cfg := config.Default()
+	cfg.Network = n.Name
+	cfg.DataDir = t.TempDir()
+	sc := work.NewShareChain(ctx, n, cfg.NetworkDataDir())
+	pm := p2p.NewPeerManager(ctx, &cfg, n, sc)
+	s := NewServer(&cfg, n, sc, pm)
+	defer func() {
+		cancel()
+		s.Wait()
+		pm.Wait()
+		sc.Wait()
+	}()
+
+	err := s.Start(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.StartAdmin(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := s.AdminAddr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+		t.Fatalf("Admin API listens on %s", ip)
+	}
+
+	get := func(addr net.Addr, path string) int {
+		r, err := nethttp.Get("http://" + addr.String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+	if status := get(s.AdminAddr(), "/admin/log_level"); status != nethttp.StatusOK {
+		t.Errorf("Admin API responded with %d", status)
+	}
+	if status := get(s.Addr(), "/admin/log_level"); status != nethttp.StatusNotFound {
+		t.Errorf("Web interface serves the admin API with status %d", status)
+	}
+	if status := get(s.Addr(), "/local_stats"); status != nethttp.StatusOK {
+		t.Errorf("Web interface responded with %d", status)
+	}
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"embed"
+	"io/fs"
+	nethttp "net/http"
+	"strconv"
+
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+//go:embed static
+var static embed.FS
+
+// Number of shares /web/recent_shares returns when no count is given
+const defaultRecentShares = 200
+
+type recentShare struct {
+	Hash      string `json:"hash"`
+	Height    int32  `json:"height"`
+	Timestamp int32  `json:"timestamp"`
+	Address   string `json:"address"`
+	StaleInfo string `json:"stale_info"`
+}
+
+func (s *Server) registerDashboard() {
+	root, _ := fs.Sub(static, "static")
+	s.Handle("/", nethttp.FileServer(nethttp.FS(root)))
+	s.HandleJSON("/web/recent_shares", s.recentShares)
+}
+
+func (s *Server) recentShares(r *nethttp.Request) (interface{}, error) {
+	count := defaultRecentShares
+	if c, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && c > 0 && c <= s.network.ChainLength {
+		count = c
+	}
+
+	res := make([]recentShare, 0, count)
+	for _, sh := range s.shareChain.GetRecentShares(count) {
+		res = append(res, recentShare{
+			Hash:      sh.Hash.String(),
+			Height:    sh.ShareInfo.AbsHeight,
+			Timestamp: sh.ShareInfo.Timestamp,
+			Address:   work.ShareAddress(sh),
+			StaleInfo: sh.ShareInfo.ShareData.StaleInfo.String(),
+		})
+	}
+	return res, nil
+}
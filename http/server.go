@@ -0,0 +1,210 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/config"
+	"github.com/gertjaap/p2pool-go/metrics"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/p2p"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// Server exposes the node status over HTTP, using the same endpoints and
+// response formats as the reference implementation's web interface so
+// existing dashboards and monitoring scripts work against it.
+type Server struct {
+	network     p2pnet.Network
+	cfg         *config.Config
+	shareChain  *work.ShareChain
+	peerManager *p2p.PeerManager
+	startTime   time.Time
+
+	mux           *nethttp.ServeMux
+	adminMux      *nethttp.ServeMux
+	listener      net.Listener
+	adminListener net.Listener
+	wg            sync.WaitGroup
+}
+
+func NewServer(cfg *config.Config, n p2pnet.Network, sc *work.ShareChain, pm *p2p.PeerManager) *Server {
+	s := &Server{
+		network:     n,
+		cfg:         cfg,
+		shareChain:  sc,
+		peerManager: pm,
+		startTime:   time.Now(),
+		mux:         nethttp.NewServeMux(),
+		adminMux:    nethttp.NewServeMux(),
+	}
+
+	s.HandleJSON("/local_stats", s.localStats)
+	s.HandleJSON("/global_stats", s.globalStats)
+	s.HandleJSON("/current_payouts", s.currentPayouts)
+	s.HandleJSON("/users", s.users)
+	s.HandleJSON("/user_stales", s.userStales)
+	s.HandleJSON("/user_hash_rates", s.userHashRates)
+	s.HandleJSON("/fee", s.fee)
+	s.HandleJSON("/peer_addresses", s.peerAddresses)
+	s.HandleJSON("/peer_list", s.peerList)
+	s.HandleJSON("/recent_blocks", s.recentBlocks)
+	s.HandleJSON("/web/version", s.version)
+	s.HandleJSON("/web/currency_info", s.currencyInfo)
+	s.Handle("/metrics", metrics.Handler())
+	s.registerDashboard()
+	s.registerAdmin()
+	return s
+}
+
+// HandleJSON registers a handler whose result is sent to the client as JSON
+func (s *Server) HandleJSON(pattern string, f func(r *nethttp.Request) (interface{}, error)) {
+	s.mux.HandleFunc(pattern, jsonHandler(f))
+}
+
+func jsonHandler(f func(r *nethttp.Request) (interface{}, error)) nethttp.HandlerFunc {
+	return func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		res, err := f(r)
+		if err != nil {
+			status := nethttp.StatusInternalServerError
+			var se *statusError
+			if errors.As(err, &se) {
+				status = se.status
+			}
+			log.Warnf("Error handling %s: %s", r.URL.Path, err.Error())
+			nethttp.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		err = json.NewEncoder(w).Encode(res)
+		if err != nil {
+			log.Warnf("Error writing response for %s: %s", r.URL.Path, err.Error())
+		}
+	}
+}
+
+// statusError is returned by handlers to respond with a status other than
+// 500 Internal Server Error
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// Handle registers a plain handler
+func (s *Server) Handle(pattern string, h nethttp.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Start listens on port and serves requests until ctx is cancelled
+func (s *Server) Start(ctx context.Context, port int) error {
+	l, err := s.serve(ctx, fmt.Sprintf(":%d", port), s.mux)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	log.Infof("Web interface listening on %s", l.Addr().String())
+	return nil
+}
+
+// StartAdmin serves the admin API on port of the loopback interface until
+// ctx is cancelled. It has its own listener so that it cannot be reached
+// from other machines, not even through a reverse proxy in front of the web
+// interface.
+func (s *Server) StartAdmin(ctx context.Context, port int) error {
+	l, err := s.serve(ctx, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), s.adminMux)
+	if err != nil {
+		return err
+	}
+	s.adminListener = l
+	log.Infof("Admin API listening on %s", l.Addr().String())
+	return nil
+}
+
+func (s *Server) serve(ctx context.Context, addr string, h nethttp.Handler) (net.Listener, error) {
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &nethttp.Server{Handler: h}
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		err := server.Serve(l)
+		if err != nil && err != nethttp.ErrServerClosed {
+			log.Errorf("Web server stopped: %s", err.Error())
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	return l, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// AdminAddr returns the address the admin API listens on
+func (s *Server) AdminAddr() net.Addr {
+	return s.adminListener.Addr()
+}
+
+// Wait blocks until the server has shut down
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Server) fee(r *nethttp.Request) (interface{}, error) {
+	return s.cfg.Fee, nil
+}
+
+func (s *Server) version(r *nethttp.Request) (interface{}, error) {
+	return "p2pool-go/" + p2p.Version, nil
+}
+
+func (s *Server) currencyInfo(r *nethttp.Request) (interface{}, error) {
+	return map[string]string{
+		"symbol":                      s.network.Symbol,
+		"block_explorer_url_prefix":   s.network.BlockExplorerURLPrefix,
+		"address_explorer_url_prefix": s.network.AddressExplorerURLPrefix,
+		"tx_explorer_url_prefix":      s.network.TxExplorerURLPrefix,
+	}, nil
+}
+
+func (s *Server) peerAddresses(r *nethttp.Request) (interface{}, error) {
+	return strings.Join(s.peerManager.GetPeerAddresses(), " "), nil
+}
+
+// peerList is not part of the reference implementation. It lists the
+// connected peers with their version, round trip time in seconds and send
+// queue.
+func (s *Server) peerList(r *nethttp.Request) (interface{}, error) {
+	return s.peerManager.GetPeerInfos(), nil
+}
+
+// recentBlocks lists the blocks found by the pool, newest first. Besides the
+// fields of the reference implementation it has the finder, the reward and
+// whether the block made it into the chain.
+func (s *Server) recentBlocks(r *nethttp.Request) (interface{}, error) {
+	return s.shareChain.Blocks.Blocks(recentBlocksCount), nil
+}
@@ -0,0 +1,180 @@
+'use strict';
+
+var currency = {};
+
+function getJSON(path) {
+  return fetch(path).then(function (r) {
+    if (!r.ok) {
+      throw new Error(path + ': ' + r.status);
+    }
+    return r.json();
+  });
+}
+
+function formatHashrate(h) {
+  var units = ['H/s', 'kH/s', 'MH/s', 'GH/s', 'TH/s', 'PH/s', 'EH/s'];
+  var i = 0;
+  while (h >= 1000 && i < units.length - 1) {
+    h /= 1000;
+    i++;
+  }
+  return h.toFixed(2) + ' ' + units[i];
+}
+
+function formatDuration(s) {
+  var d = Math.floor(s / 86400);
+  var h = Math.floor(s % 86400 / 3600);
+  var m = Math.floor(s % 3600 / 60);
+  return (d > 0 ? d + 'd ' : '') + h + 'h ' + m + 'm';
+}
+
+function link(prefix, text) {
+  if (!prefix) {
+    return document.createTextNode(text);
+  }
+  var a = document.createElement('a');
+  a.href = prefix + text;
+  a.textContent = text;
+  return a;
+}
+
+function fillTable(id, rows) {
+  var tbody = document.querySelector('#' + id + ' tbody');
+  tbody.innerHTML = '';
+  rows.forEach(function (row) {
+    var tr = document.createElement('tr');
+    row.forEach(function (cell) {
+      var td = document.createElement('td');
+      td.appendChild(typeof cell === 'object' ? cell : document.createTextNode(cell));
+      tr.appendChild(td);
+    });
+    tbody.appendChild(tr);
+  });
+}
+
+function setText(id, text) {
+  document.getElementById(id).textContent = text;
+}
+
+function sum(obj) {
+  return Object.keys(obj).reduce(function (a, k) { return a + obj[k]; }, 0);
+}
+
+function updateStats() {
+  getJSON('/local_stats').then(function (ls) {
+    // Miner hashrates are only reported once miners can connect
+    var rates = ls.miner_hash_rates || {}, deadRates = ls.miner_dead_hash_rates || {};
+    setText('local-hashrate', ls.miner_hash_rates ? formatHashrate(sum(rates)) : '-');
+    setText('miner-count', ls.miner_hash_rates ? Object.keys(rates).length : '-');
+    setText('peer-count', (ls.peers.incoming + ls.peers.outgoing) + ' (' + ls.peers.incoming + ' in, ' + ls.peers.outgoing + ' out)');
+    setText('block-value', ls.block_value.toFixed(8) + ' ' + (currency.symbol || ''));
+    setText('uptime', formatDuration(ls.uptime));
+    setText('efficiency', ls.efficiency === null ? '-' : (ls.efficiency * 100).toFixed(1) + '%');
+    fillTable('miners', Object.keys(rates).sort().map(function (addr) {
+      return [link(currency.address_explorer_url_prefix, addr),
+        formatHashrate(rates[addr]),
+        formatHashrate(deadRates[addr] || 0)];
+    }));
+  });
+
+  getJSON('/global_stats').then(function (gs) {
+    setText('pool-hashrate', formatHashrate(gs.pool_hash_rate));
+    setText('pool-stale', (gs.pool_stale_prop * 100).toFixed(1) + '%');
+  });
+
+  Promise.all([getJSON('/current_payouts'), getJSON('/users'), getJSON('/user_stales'), getJSON('/user_hash_rates')]).then(function (res) {
+    var payouts = res[0], users = res[1], stales = res[2], rates = res[3];
+    fillTable('payouts', Object.keys(payouts).sort(function (a, b) { return payouts[b] - payouts[a]; }).map(function (addr) {
+      return [link(currency.address_explorer_url_prefix, addr),
+        payouts[addr].toFixed(8) + ' ' + (currency.symbol || ''),
+        ((users[addr] || 0) * 100).toFixed(2) + '%',
+        addr in rates ? formatHashrate(rates[addr].total) : '-',
+        addr in stales ? (stales[addr] * 100).toFixed(1) + '%' : '-'];
+    }));
+  });
+
+  getJSON('/peer_list').then(function (peers) {
+    fillTable('peers', peers.map(function (p) {
+      return [p.address, p.inbound ? 'in' : 'out', p.sub_version,
+        p.rtt ? (p.rtt * 1000).toFixed(0) + ' ms' : '-'];
+    }));
+  });
+
+  getJSON('/recent_blocks').then(function (blocks) {
+    fillTable('blocks', blocks.map(function (b) {
+      return [new Date(b.ts * 1000).toLocaleString(), b.number, link(currency.block_explorer_url_prefix, b.hash),
+        link(currency.address_explorer_url_prefix, b.finder), b.status];
+    }));
+  });
+
+  getJSON('/web/recent_shares').then(drawShareGraph);
+}
+
+// Number of minutes the share graph covers at most
+var graphMinutes = 24 * 60;
+
+// Draws the number of shares per minute, stacked by stale info
+function drawShareGraph(shares) {
+  var svg = document.getElementById('share-graph');
+  var width = svg.clientWidth, height = svg.clientHeight;
+  svg.innerHTML = '';
+  if (shares.length === 0) {
+    return;
+  }
+
+  // Timestamps come from peers, so a share far in the past must not stretch
+  // the graph: only the last day before the newest share is drawn
+  var newest = shares.reduce(function (m, s) { return Math.max(m, Math.floor(s.timestamp / 60)); }, 0);
+  var buckets = {};
+  var oldest = newest;
+  shares.forEach(function (s) {
+    var minute = Math.floor(s.timestamp / 60);
+    if (minute < newest - graphMinutes) {
+      return;
+    }
+    oldest = Math.min(oldest, minute);
+    buckets[minute] = buckets[minute] || { none: 0, orphan: 0, doa: 0 };
+    if (buckets[minute][s.stale_info] !== undefined) {
+      buckets[minute][s.stale_info]++;
+    }
+  });
+
+  var minutes = newest - oldest + 1;
+  var max = Object.keys(buckets).reduce(function (m, k) {
+    var b = buckets[k];
+    return Math.max(m, b.none + b.orphan + b.doa);
+  }, 1);
+  var barWidth = width / minutes;
+
+  Object.keys(buckets).forEach(function (key) {
+    var minute = Number(key);
+    var b = buckets[key];
+    var y = height;
+    ['none', 'orphan', 'doa'].forEach(function (kind) {
+      var h = b[kind] / max * (height - 10);
+      if (h === 0) {
+        return;
+      }
+      y -= h;
+      var rect = document.createElementNS('http://www.w3.org/2000/svg', 'rect');
+      rect.setAttribute('x', (minute - oldest) * barWidth);
+      rect.setAttribute('y', y);
+      rect.setAttribute('width', Math.max(barWidth - 1, 1));
+      rect.setAttribute('height', h);
+      rect.setAttribute('class', kind);
+      var title = document.createElementNS('http://www.w3.org/2000/svg', 'title');
+      title.textContent = new Date(minute * 60000).toLocaleTimeString() + ': ' + b[kind] + ' ' + kind;
+      rect.appendChild(title);
+      svg.appendChild(rect);
+    });
+  });
+}
+
+Promise.all([getJSON('/web/version'), getJSON('/web/currency_info')]).then(function (res) {
+  setText('version', res[0]);
+  currency = res[1];
+  setText('currency', currency.symbol);
+}).finally(function () {
+  updateStats();
+  setInterval(updateStats, 10000);
+});
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"math"
+	"math/big"
+	nethttp "net/http"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/gertjaap/p2pool-go/p2p"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// Number of shares /users looks back over, like the reference implementation
+const usersLookbehind = 720
+
+// Number of blocks /recent_blocks returns
+const recentBlocksCount = 100
+
+type peerCounts struct {
+	Incoming int `json:"incoming"`
+	Outgoing int `json:"outgoing"`
+}
+
+type shareCounts struct {
+	Total  int `json:"total"`
+	Orphan int `json:"orphan"`
+	Dead   int `json:"dead"`
+}
+
+type localStats struct {
+	Peers                    peerCounts  `json:"peers"`
+	Uptime                   float64     `json:"uptime"`
+	Shares                   shareCounts `json:"shares"`
+	Efficiency               *float64    `json:"efficiency"`
+	EfficiencyIfMinerPerfect *float64    `json:"efficiency_if_miner_perfect"`
+	AttemptsToShare          float64     `json:"attempts_to_share"`
+	AttemptsToBlock          float64     `json:"attempts_to_block"`
+	BlockValue               float64     `json:"block_value"`
+	Warnings                 []string    `json:"warnings"`
+	DonationProportion       float64     `json:"donation_proportion"`
+	Version                  string      `json:"version"`
+	ProtocolVersion          int         `json:"protocol_version"`
+	Fee                      float64     `json:"fee"`
+	// The hashrates of the miners connected to this node are left out
+	// until there is a stratum server to connect to
+	MinerHashRates     map[string]float64 `json:"miner_hash_rates,omitempty"`
+	MinerDeadHashRates map[string]float64 `json:"miner_dead_hash_rates,omitempty"`
+}
+
+type globalStats struct {
+	PoolHashRate           float64 `json:"pool_hash_rate"`
+	PoolNonstaleHashRate   float64 `json:"pool_nonstale_hash_rate"`
+	PoolStaleProp          float64 `json:"pool_stale_prop"`
+	MinDifficulty          float64 `json:"min_difficulty"`
+	NetworkBlockDifficulty float64 `json:"network_block_difficulty"`
+	NetworkHashrate        float64 `json:"network_hashrate"`
+}
+
+func (s *Server) localStats(r *nethttp.Request) (interface{}, error) {
+	in, out := s.peerManager.GetPeerCounts()
+	ls := localStats{
+		Peers:           peerCounts{Incoming: in, Outgoing: out},
+		Uptime:          time.Since(s.startTime).Seconds(),
+		Warnings:        []string{},
+		Version:         p2p.Version,
+		ProtocolVersion: p2p.ProtocolVersion,
+		Fee:             s.cfg.Fee,
+	}
+
+	own := s.shareChain.GetOwnStaleCounts()
+	ls.Shares = shareCounts{Total: own.Shares, Orphan: own.Orphan, Dead: own.DOA}
+	if own.Shares > 0 {
+		// Like the reference implementation, efficiency compares our stale
+		// rate to that of the pool
+		pool, _ := s.shareChain.GetStaleCounts(work.WindowShares(s.network, s.cfg.StaleWindow))
+		poolGood := 1 - pool.StaleProp()
+		efficiency := (1 - float64(own.Orphan+own.DOA)/float64(own.Shares)) / poolGood
+		ifPerfect := (1 - float64(own.Orphan)/float64(own.Shares)) / poolGood
+		ls.Efficiency = &efficiency
+		ls.EfficiencyIfMinerPerfect = &ifPerfect
+	}
+
+	tip := s.shareChain.GetTip()
+	if tip != nil {
+		ls.AttemptsToShare = bigToFloat(work.TargetToAverageAttempts(blockchain.CompactToBig(uint32(tip.ShareInfo.MaxBits))))
+		ls.AttemptsToBlock = bigToFloat(work.TargetToAverageAttempts(blockchain.CompactToBig(tip.MinHeader.Bits)))
+		ls.BlockValue = float64(tip.ShareInfo.ShareData.Subsidy) * 1e-8
+	}
+	return ls, nil
+}
+
+func (s *Server) globalStats(r *nethttp.Request) (interface{}, error) {
+	pool, _ := s.shareChain.GetStaleCounts(work.WindowShares(s.network, s.cfg.StaleWindow))
+	rate, _ := s.shareChain.GetHashRates(work.WindowShares(s.network, s.cfg.HashRateWindow))
+	gs := globalStats{
+		PoolHashRate:         rate.Total,
+		PoolNonstaleHashRate: rate.Nonstale,
+		PoolStaleProp:        pool.StaleProp(),
+	}
+	tip := s.shareChain.GetTip()
+	if tip != nil {
+		gs.MinDifficulty = work.TargetToDifficulty(blockchain.CompactToBig(uint32(tip.ShareInfo.MaxBits)))
+		gs.NetworkBlockDifficulty = work.TargetToDifficulty(blockchain.CompactToBig(tip.MinHeader.Bits))
+		// Like the reference implementation, the hashrate needed to find a
+		// block every block period at the current difficulty
+		gs.NetworkHashrate = gs.NetworkBlockDifficulty * math.Pow(2, 32) / float64(s.network.BlockPeriod)
+	}
+	return gs, nil
+}
+
+func (s *Server) currentPayouts(r *nethttp.Request) (interface{}, error) {
+	res := map[string]float64{}
+	tip := s.shareChain.GetTipHash()
+	if tip == nil {
+		return res, nil
+	}
+	payouts, err := s.shareChain.GetPayouts(tip)
+	if err != nil {
+		return nil, err
+	}
+	for addr, amount := range payouts.Amounts {
+		res[addr] = float64(amount) * 1e-8
+	}
+	return res, nil
+}
+
+func (s *Server) users(r *nethttp.Request) (interface{}, error) {
+	weights, _ := s.shareChain.GetUserWeights(usersLookbehind)
+	return weights, nil
+}
+
+// userStales returns the fraction of stale shares of every address in the
+// chain over the configured stale window, or the window given in the window
+// query parameter.
+func (s *Server) userStales(r *nethttp.Request) (interface{}, error) {
+	window, err := s.windowShares(r, s.cfg.StaleWindow)
+	if err != nil {
+		return nil, err
+	}
+	_, perAddress := s.shareChain.GetStaleCounts(window)
+	res := map[string]float64{}
+	for addr, c := range perAddress {
+		res[addr] = c.StaleProp()
+	}
+	return res, nil
+}
+
+// userHashRates returns the estimated hashrate of every address in the chain
+// over the configured hashrate window, or the window given in the window
+// query parameter.
+func (s *Server) userHashRates(r *nethttp.Request) (interface{}, error) {
+	window, err := s.windowShares(r, s.cfg.HashRateWindow)
+	if err != nil {
+		return nil, err
+	}
+	_, perAddress := s.shareChain.GetHashRates(window)
+	return perAddress, nil
+}
+
+// windowShares returns the number of shares in the period given in the
+// window query parameter, or in def when there is none.
+func (s *Server) windowShares(r *nethttp.Request, def time.Duration) (int, error) {
+	window := def
+	if w := r.URL.Query().Get("window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			return 0, &statusError{nethttp.StatusBadRequest, "Invalid window " + w}
+		}
+		window = d
+	}
+	return work.WindowShares(s.network, window), nil
+}
+
+func bigToFloat(i *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(i).Float64()
+	return f
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/gertjaap/p2pool-go/config"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/p2p"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+func TestGlobalStatsNetworkHashrate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	cfg := config.Default()
+	cfg.Network = n.Name
+	cfg.DataDir = t.TempDir()
+	sc := work.NewShareChain(ctx, n, cfg.NetworkDataDir())
+	pm := p2p.NewPeerManager(ctx, &cfg, n, sc)
+	s := NewServer(&cfg, n, sc, pm)
+	defer func() {
+		cancel()
+		pm.Wait()
+		sc.Wait()
+	}()
+
+	// 0x1d00ffff is difficulty 1, which takes 2^32 attempts on average
+	tmpl := sim.NewFakeFullnode(0x1d00ffff, 50*100000000).BlockTemplate()
+	share, err := sim.BuildShare(n, nil, tmpl, make([]byte, 20), wire.StaleInfoNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.AddShares([]wire.Share{share})
+
+	res, err := s.globalStats(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := res.(globalStats)
+	if math.Abs(gs.NetworkBlockDifficulty-1) > 1e-6 {
+		t.Errorf("Expected difficulty 1, got %f", gs.NetworkBlockDifficulty)
+	}
+	expected := math.Pow(2, 32) / float64(n.BlockPeriod)
+	if math.Abs(gs.NetworkHashrate-expected)/expected > 1e-6 {
+		t.Errorf("Expected network hashrate %f, got %f", expected, gs.NetworkHashrate)
+	}
+}
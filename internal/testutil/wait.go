@@ -0,0 +1,16 @@
+// Package testutil holds helpers shared by the tests of several packages.
+package testutil
+
+import "time"
+
+// WaitUntil polls cond until it returns true or timeout has passed
+func WaitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
@@ -12,12 +12,15 @@ package logging
 // Warn  -> You should probably take a look at this
 // Error -> Something failed but I'm not quitting
 // Fatal -> Bye
+//
+// The package level functions below log for the "main" subsystem. Packages
+// with their own subsystem use a Logger created with New instead.
 
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"strings"
 )
 
 type LogLevel int
@@ -29,110 +32,107 @@ const (
 	LogLevelDebug   LogLevel = 3
 )
 
-var logLevel = LogLevelError // the default
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarning:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+var std = New("main")
 
+// SetLogLevel sets the level of all subsystems that have no level of their
+// own.
 func SetLogLevel(newLevel int) {
-	logLevel = LogLevel(newLevel)
+	config.Lock()
+	config.defaultLevel = LogLevel(newLevel)
+	config.Unlock()
+}
+
+// ParseLogLevel converts a level name (debug, info, warn or error) to a
+// LogLevel.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarning, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return LogLevelError, fmt.Errorf("Unknown log level %s", s)
 }
 
+// SetLogFile writes the log to logFile in addition to stdout.
 func SetLogFile(logFile io.Writer) {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
-	logOutput := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(logOutput)
-}
-
-func getPrefix(level string) string {
-	return fmt.Sprintf("[%s]", level)
+	SetOutput(io.MultiWriter(os.Stdout, logFile))
 }
 
 func Fatalln(args ...interface{}) {
-	log.Fatalln(args...)
+	std.fatal(fmt.Sprintln(args...))
 }
 
 func Fatalf(format string, args ...interface{}) {
-	log.Fatalf(format, args...)
+	std.fatal(fmt.Sprintf(format, args...))
 }
 
 func Fatal(args ...interface{}) {
-	log.Fatal(args...)
+	std.fatal(fmt.Sprint(args...))
 }
 
 func Debugf(format string, args ...interface{}) {
-	if logLevel >= LogLevelDebug {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("DEBUG"), format), args...)
-	}
+	std.Debugf(format, args...)
 }
 
 func Infof(format string, args ...interface{}) {
-	if logLevel >= LogLevelInfo {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("INFO"), format), args...)
-	}
+	std.Infof(format, args...)
 }
 
 func Warnf(format string, args ...interface{}) {
-	if logLevel >= LogLevelWarning {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("WARN"), format), args...)
-	}
+	std.Warnf(format, args...)
 }
 
 func Errorf(format string, args ...interface{}) {
-	if logLevel >= LogLevelError {
-		log.Printf(fmt.Sprintf("%s %s", getPrefix("ERROR"), format), args...)
-	}
+	std.Errorf(format, args...)
 }
 
 func Debugln(args ...interface{}) {
-	if logLevel >= LogLevelDebug {
-		args = append([]interface{}{getPrefix("DEBUG")}, args...)
-		log.Println(args...)
-	}
+	std.print(LogLevelDebug, fmt.Sprintln(args...))
 }
 
 func Infoln(args ...interface{}) {
-	if logLevel >= LogLevelInfo {
-		args = append([]interface{}{getPrefix("INFO")}, args...)
-		log.Println(args...)
-	}
+	std.print(LogLevelInfo, fmt.Sprintln(args...))
 }
 
 func Warnln(args ...interface{}) {
-	if logLevel >= LogLevelWarning {
-		args = append([]interface{}{getPrefix("WARN")}, args...)
-		log.Println(args...)
-	}
+	std.print(LogLevelWarning, fmt.Sprintln(args...))
 }
 
 func Errorln(args ...interface{}) {
-	if logLevel >= LogLevelError {
-		args = append([]interface{}{getPrefix("ERROR")}, args...)
-		log.Println(args...)
-	}
+	std.print(LogLevelError, fmt.Sprintln(args...))
 }
 
 func Debug(args ...interface{}) {
-	if logLevel >= LogLevelDebug {
-		args = append([]interface{}{getPrefix("DEBUG")}, args...)
-		log.Print(args...)
-	}
+	std.print(LogLevelDebug, fmt.Sprint(args...))
 }
 
 func Info(args ...interface{}) {
-	if logLevel >= LogLevelInfo {
-		args = append([]interface{}{getPrefix("INFO")}, args...)
-		log.Print(args...)
-	}
+	std.print(LogLevelInfo, fmt.Sprint(args...))
 }
 
 func Warn(args ...interface{}) {
-	if logLevel >= LogLevelWarning {
-		args = append([]interface{}{getPrefix("WARN")}, args...)
-		log.Print(args...)
-	}
+	std.print(LogLevelWarning, fmt.Sprint(args...))
 }
 
 func Error(args ...interface{}) {
-	if logLevel >= LogLevelError {
-		args = append([]interface{}{getPrefix("ERROR")}, args...)
-		log.Print(args...)
-	}
+	std.print(LogLevelError, fmt.Sprint(args...))
 }
@@ -0,0 +1,281 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format selects how log records are written
+type Format int
+
+const (
+	// FormatLogfmt writes records as key=value pairs
+	FormatLogfmt Format = iota
+	// FormatJSON writes every record as a JSON object on its own line
+	FormatJSON
+)
+
+// ParseFormat converts a format name (logfmt or json) to a Format
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "logfmt", "text", "":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, fmt.Errorf("Unknown log format %s", s)
+}
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+var config = struct {
+	sync.RWMutex
+	defaultLevel LogLevel
+	levels       map[string]LogLevel
+	format       Format
+	out          io.Writer
+}{
+	defaultLevel: LogLevelError,
+	levels:       map[string]LogLevel{},
+	format:       FormatLogfmt,
+	out:          os.Stderr,
+}
+
+// Logger logs records for a single subsystem, such as "wire" or "p2p". The
+// level of each subsystem can be set independently with SetSubsystemLevel.
+type Logger struct {
+	subsystem string
+}
+
+// New returns the logger for subsystem
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// Enabled returns true when records at level are written for this subsystem
+func (l *Logger) Enabled(level LogLevel) bool {
+	config.RLock()
+	defer config.RUnlock()
+	min, ok := config.levels[l.subsystem]
+	if !ok {
+		min = config.defaultLevel
+	}
+	return level <= min
+}
+
+// Debug logs msg with the given alternating keys and values
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LogLevelDebug, msg, keyvals)
+}
+
+// Info logs msg with the given alternating keys and values
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LogLevelInfo, msg, keyvals)
+}
+
+// Warn logs msg with the given alternating keys and values
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LogLevelWarning, msg, keyvals)
+}
+
+// Error logs msg with the given alternating keys and values
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LogLevelError, msg, keyvals)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.Enabled(LogLevelDebug) {
+		l.write(LogLevelDebug.String(), fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.Enabled(LogLevelInfo) {
+		l.write(LogLevelInfo.String(), fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	if l.Enabled(LogLevelWarning) {
+		l.write(LogLevelWarning.String(), fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l.Enabled(LogLevelError) {
+		l.write(LogLevelError.String(), fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if l.Enabled(level) {
+		l.write(level.String(), msg, keyvals)
+	}
+}
+
+func (l *Logger) print(level LogLevel, msg string) {
+	l.log(level, strings.TrimSuffix(msg, "\n"), nil)
+}
+
+func (l *Logger) fatal(msg string) {
+	l.write("fatal", strings.TrimSuffix(msg, "\n"), nil)
+	os.Exit(1)
+}
+
+func (l *Logger) write(level string, msg string, keyvals []interface{}) {
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "(MISSING)")
+	}
+	fields := make([]interface{}, 0, 8+len(keyvals))
+	fields = append(fields, "time", time.Now().Format(timeFormat), "level", level, "subsystem", l.subsystem, "msg", msg)
+	fields = append(fields, keyvals...)
+
+	var buf bytes.Buffer
+	config.RLock()
+	format := config.format
+	config.RUnlock()
+	if format == FormatJSON {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	config.Lock()
+	config.out.Write(buf.Bytes())
+	config.Unlock()
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		s := valueString(fields[i+1])
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		var v []byte
+		var err error
+		switch val := fields[i+1].(type) {
+		case error, fmt.Stringer:
+			v, err = json.Marshal(valueString(val))
+		default:
+			v, err = json.Marshal(val)
+		}
+		if err != nil {
+			v, _ = json.Marshal(valueString(fields[i+1]))
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+}
+
+func valueString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case fmt.Stringer:
+		return val.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// SetOutput sets the writer all records are written to
+func SetOutput(w io.Writer) {
+	config.Lock()
+	config.out = w
+	config.Unlock()
+}
+
+// SetFormat sets the format records are written in
+func SetFormat(f Format) {
+	config.Lock()
+	config.format = f
+	config.Unlock()
+}
+
+// SetSubsystemLevel sets the level of a single subsystem
+func SetSubsystemLevel(subsystem string, level LogLevel) {
+	config.Lock()
+	config.levels[subsystem] = level
+	config.Unlock()
+}
+
+// Levels returns the default level and the levels of subsystems that have
+// their own.
+func Levels() (LogLevel, map[string]LogLevel) {
+	config.RLock()
+	defer config.RUnlock()
+	levels := make(map[string]LogLevel, len(config.levels))
+	for k, v := range config.levels {
+		levels[k] = v
+	}
+	return config.defaultLevel, levels
+}
+
+// ParseLevels parses a level specification such as "info,wire=debug,p2p=warn"
+// into a default level and per-subsystem levels. Entries without a subsystem
+// set the default level.
+func ParseLevels(spec string) (LogLevel, map[string]LogLevel, error) {
+	def := LogLevelInfo
+	levels := map[string]LogLevel{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		level, err := ParseLogLevel(parts[len(parts)-1])
+		if err != nil {
+			return def, nil, err
+		}
+		if len(parts) == 1 {
+			def = level
+		} else {
+			levels[strings.TrimSpace(parts[0])] = level
+		}
+	}
+	return def, levels, nil
+}
+
+// SetLevels applies a level specification as accepted by ParseLevels,
+// replacing all previously set levels.
+func SetLevels(spec string) error {
+	def, levels, err := ParseLevels(spec)
+	if err != nil {
+		return err
+	}
+	config.Lock()
+	config.defaultLevel = def
+	config.levels = levels
+	config.Unlock()
+	return nil
+}
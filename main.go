@@ -1,35 +1,132 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/gertjaap/p2pool-go/chaincli"
+	"github.com/gertjaap/p2pool-go/config"
+	"github.com/gertjaap/p2pool-go/http"
 	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/nat"
 	p2pnet "github.com/gertjaap/p2pool-go/net"
 	"github.com/gertjaap/p2pool-go/p2p"
+	"github.com/gertjaap/p2pool-go/rpc"
 	"github.com/gertjaap/p2pool-go/work"
 )
 
 func main() {
-	logging.SetLogLevel(int(logging.LogLevelDebug))
-	p2pnet.ActiveNetwork = p2pnet.Vertcoin()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// The usage has been printed
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	if cfg.PrintConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	logging.SetLevels(cfg.LogLevel)
+	logFormat, _ := logging.ParseFormat(cfg.LogFormat)
+	logging.SetFormat(logFormat)
+	if cfg.LogFilePath() != "" {
+		logFile, err := logging.NewRotatingFile(cfg.LogFilePath(), int64(cfg.LogMaxSize)*1024*1024, cfg.LogMaxFiles)
+		if err != nil {
+			logging.Fatal(err)
+		}
+		defer logFile.Close()
+		logging.SetLogFile(logFile)
+	}
+
+	n, err := p2pnet.ByName(cfg.Network)
+	if err != nil {
+		logging.Fatal(err)
+	}
+	if cfg.P2PPort != 0 {
+		n.P2PPort = cfg.P2PPort
+	}
 
-	sc := work.NewShareChain()
-	err := sc.Load()
+	if len(cfg.Args) > 0 {
+		if cfg.Args[0] != "chain" {
+			fmt.Fprintf(os.Stderr, "Unknown command %s\n", cfg.Args[0])
+			os.Exit(2)
+		}
+		err = chaincli.Run(cfg, n, cfg.Args[1:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	sc := work.NewShareChain(ctx, n, cfg.NetworkDataDir())
+	err = sc.Load()
 	if err != nil {
-		panic(err)
+		logging.Fatalf("Could not load the share chain: %s", err.Error())
 	}
 
+	fullnode := rpc.NewClient("http://"+cfg.RPCHost, cfg.RPCUser, cfg.RPCPassword)
+	sc.Blocks.Follow(ctx, fullnode, work.BlockCheckInterval)
+
 	//return
-	pm := p2p.NewPeerManager(p2pnet.ActiveNetwork, sc)
+	pm := p2p.NewPeerManager(ctx, cfg, n, sc)
+	err = pm.Listen(n.P2PPort)
+	if err != nil {
+		logging.Warnf("Not accepting inbound peers: %s", err.Error())
+	}
+
+	mappings := []nat.Mapping{{Name: "p2pool-go p2p", Protocol: "TCP", InternalPort: n.P2PPort}}
+	mapper := nat.NewMapper(ctx, cfg.NAT, mappings, func(ip net.IP, mappings []nat.Mapping) {
+		pm.LocalAddress().SetDiscovered(ip, mappings[0].ExternalPort)
+	})
 
-	go func() {
-		for s := range sc.NeedShareChannel {
-			pm.AskForShare(s)
+	web := http.NewServer(cfg, n, sc, pm)
+	if cfg.WebPort != 0 {
+		err = web.Start(ctx, cfg.WebPort)
+		if err != nil {
+			logging.Errorf("Could not start web interface: %s", err.Error())
+		}
+	}
+	if cfg.AdminPort != 0 {
+		err = web.StartAdmin(ctx, cfg.AdminPort)
+		if err != nil {
+			logging.Errorf("Could not start admin API: %s", err.Error())
 		}
-	}()
+	}
 
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
 	for {
-		logging.Debugf("Number of active peers: %d", pm.GetPeerCount())
-		time.Sleep(time.Second * 5)
+		select {
+		case <-ticker.C:
+			logging.Debugf("Number of active peers: %d", pm.GetPeerCount())
+		case <-ctx.Done():
+			logging.Infof("Shutting down")
+			web.Wait()
+			pm.Wait()
+			sc.Wait()
+			sc.Blocks.Wait()
+			mapper.Wait()
+			logging.Infof("Shutdown complete")
+			return
+		}
 	}
 }
@@ -0,0 +1,155 @@
+// Package metrics holds the Prometheus collectors of the node. Collectors
+// are registered with the default registry and served by Handler.
+package metrics
+
+import (
+	nethttp "net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "p2pool"
+
+// Reasons a share is rejected, used as label for InvalidShares
+const (
+	InvalidReasonPOW    = "pow"
+	InvalidReasonDecode = "decode"
+	InvalidReasonWork   = "work"
+)
+
+var (
+	// Peers is the number of connected peers by direction (in or out)
+	Peers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "peers",
+		Help:      "Number of connected peers.",
+	}, []string{"direction"})
+
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "messages_received_total",
+		Help:      "Number of p2pool messages received, by command.",
+	}, []string{"command"})
+
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "messages_sent_total",
+		Help:      "Number of p2pool messages sent, by command.",
+	}, []string{"command"})
+
+	BytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "bytes_received_total",
+		Help:      "Number of bytes received including message headers, by command.",
+	}, []string{"command"})
+
+	BytesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "bytes_sent_total",
+		Help:      "Number of bytes sent including message headers, by command.",
+	}, []string{"command"})
+
+	// SendQueueMessages and SendQueueBytes are the messages waiting to be
+	// sent, summed over all peers
+	SendQueueMessages = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "send_queue_messages",
+		Help:      "Number of messages waiting to be sent to peers.",
+	})
+
+	SendQueueBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "send_queue_bytes",
+		Help:      "Payload bytes of the messages waiting to be sent to peers.",
+	})
+
+	SendQueueDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "send_queue_dropped_total",
+		Help:      "Number of low priority messages dropped from full send queues, by command.",
+	}, []string{"command"})
+
+	StalledPeers = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "p2p",
+		Name:      "stalled_peers_total",
+		Help:      "Number of peers disconnected because they did not read the messages sent to them in time.",
+	})
+
+	ShareChainHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sharechain",
+		Name:      "height",
+		Help:      "Number of shares in the connected share chain.",
+	})
+
+	DisconnectedShares = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sharechain",
+		Name:      "disconnected_shares",
+		Help:      "Number of shares that could not be connected to the share chain yet.",
+	})
+
+	InvalidShares = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sharechain",
+		Name:      "invalid_shares_total",
+		Help:      "Number of shares rejected, by reason.",
+	}, []string{"reason"})
+
+	// SyncLag is the age of the share at the tip of the chain. A node that
+	// is in sync stays close to the network's share period.
+	SyncLag = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sharechain",
+		Name:      "sync_lag_seconds",
+		Help:      "Seconds since the timestamp of the share at the tip of the chain.",
+	}, syncLag)
+
+	FoundBlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "found_blocks_total",
+		Help:      "Number of shares seen since startup that are also valid blocks.",
+	})
+
+	RPCLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of RPC requests to the fullnode, by method.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"method"})
+)
+
+var tipTime int64
+
+// SetTipTime records the timestamp of the share at the tip of the chain,
+// which SyncLag is calculated from.
+func SetTipTime(t time.Time) {
+	atomic.StoreInt64(&tipTime, t.Unix())
+}
+
+func syncLag() float64 {
+	t := atomic.LoadInt64(&tipTime)
+	if t == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(t, 0)).Seconds()
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() nethttp.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,11 @@
+package nat
+
+import "time"
+
+// SetRenewInterval changes how often mappers renew their mappings, and
+// returns a function that restores the interval.
+func SetRenewInterval(d time.Duration) func() {
+	old := renewInterval
+	renewInterval = d
+	return func() { renewInterval = old }
+}
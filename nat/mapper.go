@@ -0,0 +1,175 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Lifetime requested for each mapping. Mappings are renewed at half their
+// lifetime, so they expire soon after the node stops without removing them.
+const mappingLifetime = 20 * time.Minute
+
+// renewInterval is how often the mappings are renewed, and how long to wait
+// before looking for a router again
+var renewInterval = mappingLifetime / 2
+
+// Mapping is a port forwarded by the router
+type Mapping struct {
+	// Name is shown in the mapping table of the router
+	Name         string
+	Protocol     string
+	InternalPort int
+	// ExternalPort is the port on the router that forwards to InternalPort.
+	// It is 0 until the port has been mapped.
+	ExternalPort int
+}
+
+// Mapper keeps ports mapped on the router until its context is cancelled,
+// and then removes the mappings.
+type Mapper struct {
+	spec     string
+	onChange func(ip net.IP, mappings []Mapping)
+
+	lock     sync.Mutex
+	nat      NAT
+	mappings []Mapping
+	external net.IP
+	wg       sync.WaitGroup
+}
+
+// NewMapper finds the router described by spec (see Discover) in the
+// background and maps the given ports. Whenever the mappings or the external
+// address change, onChange is called with the current state. Nothing is done
+// when spec is "none".
+func NewMapper(ctx context.Context, spec string, mappings []Mapping, onChange func(ip net.IP, mappings []Mapping)) *Mapper {
+	m := &Mapper{
+		spec:     spec,
+		onChange: onChange,
+		mappings: make([]Mapping, len(mappings)),
+	}
+	copy(m.mappings, mappings)
+	if spec == "none" || spec == "" {
+		return m
+	}
+	m.wg.Add(1)
+	go m.run(ctx)
+	return m
+}
+
+func (m *Mapper) run(ctx context.Context) {
+	defer m.wg.Done()
+	for {
+		n, err := Discover(m.spec)
+		if err == nil {
+			m.lock.Lock()
+			m.nat = n
+			m.lock.Unlock()
+			log.Infof("Mapping ports through %s", n.String())
+			break
+		}
+		log.Warnf("Could not find a router to map ports on: %s", err.Error())
+		select {
+		case <-time.After(renewInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for ctx.Err() == nil {
+		m.refresh()
+		select {
+		case <-time.After(renewInterval):
+		case <-ctx.Done():
+			m.unmap()
+			return
+		}
+	}
+}
+
+// refresh adds or renews all mappings and updates the external address.
+// Only the goroutine started by NewMapper changes the mappings, so the
+// requests to the router are made without holding the lock.
+func (m *Mapper) refresh() {
+	mappings := m.Mappings()
+	changed := false
+	for i := range mappings {
+		mp := &mappings[i]
+		ext := mp.ExternalPort
+		if ext == 0 {
+			ext = mp.InternalPort
+		}
+		mapped, err := m.nat.AddMapping(mp.Protocol, ext, mp.InternalPort, mp.Name, mappingLifetime)
+		if err != nil {
+			log.Warnf("Could not map %s port %d: %s", mp.Protocol, mp.InternalPort, err.Error())
+			continue
+		}
+		if mapped != mp.ExternalPort {
+			log.Infof("Mapped %s port %d to external port %d", mp.Protocol, mp.InternalPort, mapped)
+			mp.ExternalPort = mapped
+			changed = true
+		}
+	}
+	ip, err := m.nat.ExternalIP()
+
+	m.lock.Lock()
+	copy(m.mappings, mappings)
+	if err != nil {
+		log.Warnf("Could not get external IP from router: %s", err.Error())
+	} else if !ip.Equal(m.external) {
+		log.Infof("External IP is %s", ip.String())
+		m.external = ip
+		changed = true
+	}
+	external := m.external
+	m.lock.Unlock()
+
+	if changed && m.onChange != nil {
+		m.onChange(external, mappings)
+	}
+}
+
+func (m *Mapper) unmap() {
+	mappings := m.Mappings()
+	for i := range mappings {
+		mp := &mappings[i]
+		if mp.ExternalPort == 0 {
+			continue
+		}
+		err := m.nat.DeleteMapping(mp.Protocol, mp.ExternalPort, mp.InternalPort)
+		if err != nil {
+			log.Warnf("Could not remove mapping of %s port %d: %s", mp.Protocol, mp.ExternalPort, err.Error())
+			continue
+		}
+		log.Debugf("Removed mapping of %s port %d", mp.Protocol, mp.ExternalPort)
+		mp.ExternalPort = 0
+	}
+
+	m.lock.Lock()
+	copy(m.mappings, mappings)
+	m.lock.Unlock()
+}
+
+// ExternalIP returns the external address learned from the router, or nil
+// when it is not known.
+func (m *Mapper) ExternalIP() net.IP {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.external
+}
+
+// Mappings returns the state of the mappings
+func (m *Mapper) Mappings() []Mapping {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mappings := make([]Mapping, len(m.mappings))
+	copy(mappings, m.mappings)
+	return mappings
+}
+
+// Wait blocks until the mappings have been removed after the context was
+// cancelled.
+func (m *Mapper) Wait() {
+	m.wg.Wait()
+}
@@ -0,0 +1,148 @@
+package nat_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/internal/testutil"
+	"github.com/gertjaap/p2pool-go/nat"
+	"github.com/gertjaap/p2pool-go/sim"
+)
+
+// fakeRouter is what the tests need from the fake routers in sim
+type fakeRouter interface {
+	AddRequests() int
+	Close() error
+}
+
+var testMappings = []nat.Mapping{
+	{Name: "p2pool-go p2p", Protocol: "TCP", InternalPort: 19333},
+	{Name: "p2pool-go test", Protocol: "TCP", InternalPort: 19332},
+}
+
+// testMapper maps testMappings through the router at spec, checks that
+// they are renewed and removed on shutdown, and that the external address is
+// learned. mapped returns the internal and external ports the router holds.
+func testMapper(t *testing.T, spec string, router fakeRouter, externalIP net.IP, mapped func() map[int]int) {
+	defer nat.SetRenewInterval(100 * time.Millisecond)()
+
+	var lock sync.Mutex
+	var changedIP net.IP
+	changes := 0
+	onChange := func(ip net.IP, mappings []nat.Mapping) {
+		lock.Lock()
+		defer lock.Unlock()
+		changedIP = ip
+		changes++
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := nat.NewMapper(ctx, spec, testMappings, onChange)
+	ok := testutil.WaitUntil(5*time.Second, func() bool { return len(mapped()) == len(testMappings) })
+	if !ok {
+		cancel()
+		t.Fatalf("Ports not mapped, router has %v", mapped())
+	}
+	for _, mp := range testMappings {
+		if ext := mapped()[mp.InternalPort]; ext != mp.InternalPort {
+			t.Errorf("Expected port %d mapped to the same external port, got %d", mp.InternalPort, ext)
+		}
+	}
+
+	// External address discovery
+	ok = testutil.WaitUntil(5*time.Second, func() bool { return m.ExternalIP().Equal(externalIP) })
+	if !ok {
+		cancel()
+		t.Fatalf("Expected external IP %s, got %s", externalIP, m.ExternalIP())
+	}
+	lock.Lock()
+	if !changedIP.Equal(externalIP) || changes != 1 {
+		t.Errorf("Expected a single change to external IP %s, got %d changes to %s", externalIP, changes, changedIP)
+	}
+	lock.Unlock()
+	for _, mp := range m.Mappings() {
+		if mp.ExternalPort != mp.InternalPort {
+			t.Errorf("Expected mapper to report port %d mapped, got %d", mp.InternalPort, mp.ExternalPort)
+		}
+	}
+
+	// Renewal repeats the requests without reporting a change
+	renewed := testutil.WaitUntil(5*time.Second, func() bool { return router.AddRequests() >= 3*len(testMappings) })
+	if !renewed {
+		cancel()
+		t.Fatalf("Mappings not renewed, %d requests", router.AddRequests())
+	}
+	lock.Lock()
+	if changes != 1 {
+		t.Errorf("Expected renewals not to report changes, got %d changes", changes)
+	}
+	lock.Unlock()
+
+	// Unmap on shutdown
+	cancel()
+	m.Wait()
+	if len(mapped()) != 0 {
+		t.Errorf("Expected mappings removed on shutdown, router has %v", mapped())
+	}
+	for _, mp := range m.Mappings() {
+		if mp.ExternalPort != 0 {
+			t.Errorf("Expected port %d reported unmapped, got %d", mp.InternalPort, mp.ExternalPort)
+		}
+	}
+}
+
+func TestMapperUPnP(t *testing.T) {
+	externalIP := net.ParseIP("203.0.113.7")
+	igd, err := sim.NewFakeIGD(externalIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	testMapper(t, "upnp:"+igd.URL(), igd, externalIP, func() map[int]int {
+		ports := map[int]int{}
+		for _, mp := range igd.Mappings() {
+			if mp.InternalClient == "" || mp.Lease <= 0 {
+				t.Errorf("Mapping without client or lease: %+v", mp)
+			}
+			ports[mp.InternalPort] = mp.ExternalPort
+		}
+		return ports
+	})
+}
+
+func TestMapperNATPMP(t *testing.T) {
+	externalIP := net.ParseIP("203.0.113.8")
+	gateway := net.ParseIP("127.0.0.53")
+	pmp, err := sim.NewFakePMP(gateway, externalIP)
+	if err != nil {
+		t.Skipf("Cannot listen on the NAT-PMP port: %s", err.Error())
+	}
+	defer pmp.Close()
+
+	testMapper(t, "pmp:"+gateway.String(), pmp, externalIP, func() map[int]int {
+		ports := map[int]int{}
+		for _, mp := range pmp.Mappings() {
+			if mp.Protocol != "tcp" || mp.Lifetime <= 0 {
+				t.Errorf("Unexpected mapping %+v", mp)
+			}
+			ports[mp.InternalPort] = mp.ExternalPort
+		}
+		return ports
+	})
+}
+
+func TestMapperNone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := nat.NewMapper(ctx, "none", testMappings, func(net.IP, []nat.Mapping) {
+		t.Error("Expected no changes without a router")
+	})
+	cancel()
+	m.Wait()
+	if m.ExternalIP() != nil || m.Mappings()[0].ExternalPort != 0 {
+		t.Errorf("Expected nothing mapped, got %s and %v", m.ExternalIP(), m.Mappings())
+	}
+}
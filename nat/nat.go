@@ -0,0 +1,100 @@
+// Package nat maps ports on the router through UPnP IGD or NAT-PMP, so that
+// nodes behind a home router can accept inbound peers and miners, and learns
+// the external address of the node from the router.
+package nat
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Timeout for a single request to the router
+const requestTimeout = 5 * time.Second
+
+// NAT is a router that can map ports
+type NAT interface {
+	// ExternalIP returns the address of the router on the internet
+	ExternalIP() (net.IP, error)
+	// AddMapping forwards extPort on the router to intPort of this host for
+	// lifetime, and returns the external port actually mapped.
+	AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error)
+	// DeleteMapping removes a mapping made by AddMapping
+	DeleteMapping(protocol string, extPort, intPort int) error
+	String() string
+}
+
+// CheckSpec validates a port mapping method as accepted by Discover
+func CheckSpec(spec string) error {
+	method, arg := splitSpec(spec)
+	switch method {
+	case "none", "auto":
+		if arg != "" {
+			return fmt.Errorf("NAT method %s takes no argument", method)
+		}
+	case "upnp":
+		if arg != "" {
+			u, err := url.Parse(arg)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("Invalid UPnP device URL %s", arg)
+			}
+		}
+	case "pmp":
+		if arg != "" && net.ParseIP(arg) == nil {
+			return fmt.Errorf("Invalid NAT-PMP gateway %s", arg)
+		}
+	default:
+		return fmt.Errorf("Unknown NAT method %s, use none, auto, upnp[:<device URL>] or pmp[:<gateway>]", spec)
+	}
+	return nil
+}
+
+// Discover finds the router described by spec:
+//
+//	none                  no port mapping, returns a nil NAT
+//	auto                  UPnP, then NAT-PMP
+//	upnp                  a UPnP IGD found on the local network
+//	upnp:<device URL>     the UPnP IGD whose root description is at the URL
+//	pmp                   NAT-PMP on the likely gateway addresses
+//	pmp:<gateway>         NAT-PMP on the given gateway
+//
+// Discovery sends requests on the local network and can take several
+// seconds.
+func Discover(spec string) (NAT, error) {
+	err := CheckSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	method, arg := splitSpec(spec)
+	switch method {
+	case "upnp":
+		if arg != "" {
+			u, _ := url.Parse(arg)
+			return upnpByURL(u)
+		}
+		return discoverUPnP()
+	case "pmp":
+		if arg != "" {
+			return pmpByGateway(net.ParseIP(arg))
+		}
+		return discoverPMP()
+	case "auto":
+		n, err := discoverUPnP()
+		if err == nil {
+			return n, nil
+		}
+		log.Debugf("No UPnP gateway: %s", err.Error())
+		return discoverPMP()
+	}
+	return nil, nil
+}
+
+func splitSpec(spec string) (string, string) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) == 1 {
+		return strings.ToLower(parts[0]), ""
+	}
+	return strings.ToLower(parts[0]), parts[1]
+}
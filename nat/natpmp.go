@@ -0,0 +1,88 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	natpmp "github.com/jackpal/go-nat-pmp"
+)
+
+type pmp struct {
+	gateway net.IP
+	client  *natpmp.Client
+}
+
+func pmpByGateway(gateway net.IP) (NAT, error) {
+	p := &pmp{gateway: gateway, client: natpmp.NewClientWithTimeout(gateway, requestTimeout)}
+	_, err := p.ExternalIP()
+	if err != nil {
+		return nil, fmt.Errorf("No NAT-PMP gateway at %s: %s", gateway.String(), err.Error())
+	}
+	return p, nil
+}
+
+// discoverPMP tries the addresses routers usually have on the local networks
+// this host is part of.
+func discoverPMP() (NAT, error) {
+	for _, gw := range likelyGateways() {
+		p, err := pmpByGateway(gw)
+		if err == nil {
+			return p, nil
+		}
+		log.Debugf("%s", err.Error())
+	}
+	return nil, fmt.Errorf("No NAT-PMP gateway found")
+}
+
+// likelyGateways returns the .1 address of every private IPv4 network this
+// host has an address in
+func likelyGateways() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	gws := make([]net.IP, 0)
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP.To4()
+		if ip == nil || !ip.IsPrivate() {
+			continue
+		}
+		gw := ip.Mask(ipnet.Mask)
+		gw[3] |= 1
+		if !gw.Equal(ip) {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+func (p *pmp) ExternalIP() (net.IP, error) {
+	res, err := p.client.GetExternalAddress()
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(res.ExternalIPAddress[:]), nil
+}
+
+func (p *pmp) AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error) {
+	res, err := p.client.AddPortMapping(strings.ToLower(protocol), intPort, extPort, int(lifetime/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return int(res.MappedExternalPort), nil
+}
+
+func (p *pmp) DeleteMapping(protocol string, extPort, intPort int) error {
+	_, err := p.client.AddPortMapping(strings.ToLower(protocol), intPort, 0, 0)
+	return err
+}
+
+func (p *pmp) String() string {
+	return fmt.Sprintf("NAT-PMP at %s", p.gateway.String())
+}
@@ -0,0 +1,133 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/dcps/internetgateway2"
+)
+
+// igdClient holds the actions shared by the WAN connection services of
+// version 1 and 2 of the Internet Gateway Device protocol
+type igdClient interface {
+	GetExternalIPAddress() (string, error)
+	AddPortMapping(remoteHost string, extPort uint16, protocol string, intPort uint16, intClient string, enabled bool, desc string, lease uint32) error
+	DeletePortMapping(remoteHost string, extPort uint16, protocol string) error
+}
+
+// igdServices are the services that can map ports, most capable first
+var igdServices = []struct {
+	urn  string
+	wrap func(goupnp.ServiceClient) igdClient
+}{
+	{internetgateway2.URN_WANIPConnection_2, func(sc goupnp.ServiceClient) igdClient {
+		return &internetgateway2.WANIPConnection2{ServiceClient: sc}
+	}},
+	{internetgateway1.URN_WANIPConnection_1, func(sc goupnp.ServiceClient) igdClient {
+		return &internetgateway1.WANIPConnection1{ServiceClient: sc}
+	}},
+	{internetgateway1.URN_WANPPPConnection_1, func(sc goupnp.ServiceClient) igdClient {
+		return &internetgateway1.WANPPPConnection1{ServiceClient: sc}
+	}},
+}
+
+type upnp struct {
+	client   igdClient
+	location *url.URL
+	service  string
+	// internal is the address of this host on the network of the router
+	internal net.IP
+}
+
+func discoverUPnP() (NAT, error) {
+	for _, s := range igdServices {
+		clients, _, err := goupnp.NewServiceClients(s.urn)
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range clients {
+			u, err := newUPnP(sc, s.urn, s.wrap)
+			if err == nil {
+				return u, nil
+			}
+			log.Debugf("Skipping UPnP device at %s: %s", sc.Location.String(), err.Error())
+		}
+	}
+	return nil, fmt.Errorf("No UPnP gateway found")
+}
+
+func upnpByURL(loc *url.URL) (NAT, error) {
+	root, err := goupnp.DeviceByURL(loc)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range igdServices {
+		clients, err := goupnp.NewServiceClientsFromRootDevice(root, loc, s.urn)
+		if err != nil {
+			continue
+		}
+		return newUPnP(clients[0], s.urn, s.wrap)
+	}
+	return nil, fmt.Errorf("UPnP device at %s cannot map ports", loc.String())
+}
+
+func newUPnP(sc goupnp.ServiceClient, urn string, wrap func(goupnp.ServiceClient) igdClient) (*upnp, error) {
+	sc.SOAPClient.HTTPClient.Timeout = requestTimeout
+	internal, err := localAddrTo(sc.Location.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &upnp{client: wrap(sc), location: sc.Location, service: urn, internal: internal}, nil
+}
+
+// localAddrTo returns the address of the interface used to reach host
+func localAddrTo(host string) (net.IP, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func (u *upnp) ExternalIP() (net.IP, error) {
+	s, err := u.client.GetExternalIPAddress()
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("Router returned invalid external IP %q", s)
+	}
+	return ip, nil
+}
+
+func (u *upnp) AddMapping(protocol string, extPort, intPort int, name string, lifetime time.Duration) (int, error) {
+	protocol = strings.ToUpper(protocol)
+	err := u.client.AddPortMapping("", uint16(extPort), protocol, uint16(intPort), u.internal.String(), true, name, uint32(lifetime/time.Second))
+	if err != nil {
+		// Some routers only accept permanent mappings, which are still
+		// removed on shutdown
+		err = u.client.AddPortMapping("", uint16(extPort), protocol, uint16(intPort), u.internal.String(), true, name, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return extPort, nil
+}
+
+func (u *upnp) DeleteMapping(protocol string, extPort, intPort int) error {
+	return u.client.DeletePortMapping("", uint16(extPort), strings.ToUpper(protocol))
+}
+
+func (u *upnp) String() string {
+	return fmt.Sprintf("UPnP %s at %s", u.service, u.location.Host)
+}
@@ -0,0 +1,36 @@
+package net
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+func Bitcoin() Network {
+	n := Network{Name: "bitcoin", P2PPort: 9333}
+	n.MessagePrefix, _ = hex.DecodeString("2472ef181efcd37b")
+	n.Identifier, _ = hex.DecodeString("fc70035c7a81bc6f")
+	n.MinProtocolVersion = 1700
+	n.SharePeriod = 30
+	n.ChainLength = 24 * 60 * 60 / 10
+	n.RealChainLength = 24 * 60 * 60 / 10
+	n.TargetLookbehind = 200
+	n.Spread = 3
+	n.MinTarget = big.NewInt(0)
+	n.MaxTarget = maxTarget(32)
+	n.DonationScript, _ = hex.DecodeString(defaultDonationScript)
+	n.MinShareVersion = 16
+	n.SegwitActivationVersion = 17
+	n.SoftForks = []string{"bip65", "csv", "segwit"}
+	n.CoinbaseMaturity = 100
+	n.BlockPeriod = 600
+	n.AddressVersion = 0
+	n.ScriptAddressVersion = 5
+	n.Bech32Prefix = "bc"
+	n.Symbol = "BTC"
+	n.BlockExplorerURLPrefix = "https://blockchain.info/block/"
+	n.AddressExplorerURLPrefix = "https://blockchain.info/address/"
+	n.TxExplorerURLPrefix = "https://blockchain.info/tx/"
+	n.SeedHosts = []string{"forre.st", "vps.forre.st"}
+	n.POWHash = sha256dPOW
+	return n
+}
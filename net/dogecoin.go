@@ -0,0 +1,35 @@
+package net
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+func Dogecoin() Network {
+	n := Network{Name: "dogecoin", P2PPort: 8555}
+	n.MessagePrefix, _ = hex.DecodeString("d0d5d7d8b3f68cd9")
+	n.Identifier, _ = hex.DecodeString("d0d1d2d3b2f68cd9")
+	n.MinProtocolVersion = 1700
+	n.SharePeriod = 15
+	n.ChainLength = 24 * 60 * 60 / 10
+	n.RealChainLength = 24 * 60 * 60 / 10
+	n.TargetLookbehind = 200
+	n.Spread = 10
+	n.MinTarget = big.NewInt(0)
+	n.MaxTarget = maxTarget(20)
+	n.DonationScript, _ = hex.DecodeString(defaultDonationScript)
+	n.MinShareVersion = 16
+	n.SegwitActivationVersion = 0
+	n.SoftForks = []string{}
+	n.CoinbaseMaturity = 240
+	n.BlockPeriod = 60
+	n.AddressVersion = 30
+	n.ScriptAddressVersion = 22
+	n.Symbol = "DOGE"
+	n.BlockExplorerURLPrefix = "https://dogechain.info/block/"
+	n.AddressExplorerURLPrefix = "https://dogechain.info/address/"
+	n.TxExplorerURLPrefix = "https://dogechain.info/tx/"
+	n.SeedHosts = []string{}
+	n.POWHash = scryptPOW
+	return n
+}
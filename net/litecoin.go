@@ -0,0 +1,36 @@
+package net
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+func Litecoin() Network {
+	n := Network{Name: "litecoin", P2PPort: 9338}
+	n.MessagePrefix, _ = hex.DecodeString("7208c1a53ef629b0")
+	n.Identifier, _ = hex.DecodeString("e037d5b8c6923410")
+	n.MinProtocolVersion = 1700
+	n.SharePeriod = 15
+	n.ChainLength = 24 * 60 * 60 / 10
+	n.RealChainLength = 24 * 60 * 60 / 10
+	n.TargetLookbehind = 200
+	n.Spread = 3
+	n.MinTarget = big.NewInt(0)
+	n.MaxTarget = maxTarget(20)
+	n.DonationScript, _ = hex.DecodeString(defaultDonationScript)
+	n.MinShareVersion = 16
+	n.SegwitActivationVersion = 17
+	n.SoftForks = []string{"bip65", "csv", "segwit"}
+	n.CoinbaseMaturity = 100
+	n.BlockPeriod = 150
+	n.AddressVersion = 48
+	n.ScriptAddressVersion = 50
+	n.Bech32Prefix = "ltc"
+	n.Symbol = "LTC"
+	n.BlockExplorerURLPrefix = "https://chainz.cryptoid.info/ltc/block.dws?"
+	n.AddressExplorerURLPrefix = "https://chainz.cryptoid.info/ltc/address.dws?"
+	n.TxExplorerURLPrefix = "https://chainz.cryptoid.info/ltc/tx.dws?"
+	n.SeedHosts = []string{"forre.st", "vps.forre.st"}
+	n.POWHash = scryptPOW
+	return n
+}
@@ -1,31 +1,143 @@
 package net
 
 import (
-	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
 
-	"github.com/adamcollier1/lyra2rev3"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/gertjaap/p2pool-go/util"
+	"golang.org/x/crypto/scrypt"
 )
 
-var ActiveNetwork Network
-
 type Network struct {
+	Name          string
 	MessagePrefix []byte
 	Identifier    []byte
 	P2PPort       int
 	SeedHosts     []string
-	ChainLength   int
-	POWHash       func([]byte) []byte
-}
-
-func Vertcoin() Network {
-	n := Network{P2PPort: 9346}
-	n.MessagePrefix, _ = hex.DecodeString("7c3614a6bcdcf784")
-	n.Identifier, _ = hex.DecodeString("a06a81c827cab983")
-	n.ChainLength = 5100
-	n.SeedHosts = []string{"localhost", "p2proxy.vertcoin.org", "vtc.alwayshashing.com", "crypto.office-on-the.net", "pool.vtconline.org"}
-	n.POWHash = func(b []byte) []byte {
-		res, _ := lyra2rev3.SumV3(b)
-		return res
+
+	// MinProtocolVersion is the oldest p2pool protocol version peers may
+	// speak. Peers announcing an older version are disconnected.
+	MinProtocolVersion int32
+
+	// Share chain parameters, named after their counterparts in the
+	// reference implementation's network definitions.
+	SharePeriod      int // seconds
+	ChainLength      int // shares
+	RealChainLength  int // shares
+	TargetLookbehind int // shares
+	Spread           int // blocks
+	MinTarget        *big.Int
+	MaxTarget        *big.Int
+	DonationScript   []byte
+	SoftForks        []string
+
+	// Share versions: shares below MinShareVersion are rejected, shares
+	// at or above SegwitActivationVersion carry segwit data. A zero
+	// SegwitActivationVersion means segwit shares are not used.
+	MinShareVersion         uint64
+	SegwitActivationVersion uint64
+
+	// CoinbaseMaturity is the number of confirmations after which a block
+	// reward of the parent chain can be spent, and a found block is
+	// considered confirmed
+	CoinbaseMaturity int
+
+	// BlockPeriod is the target time between blocks of the parent chain in
+	// seconds
+	BlockPeriod int
+
+	// Parent chain address encoding
+	AddressVersion       byte
+	ScriptAddressVersion byte
+	Bech32Prefix         string
+
+	// Parent chain currency information for the web interface
+	Symbol                   string
+	BlockExplorerURLPrefix   string
+	AddressExplorerURLPrefix string
+	TxExplorerURLPrefix      string
+
+	POWHash func([]byte) []byte
+}
+
+var networks = map[string]func() Network{
+	"bitcoin":          Bitcoin,
+	"litecoin":         Litecoin,
+	"vertcoin":         Vertcoin,
+	"vertcoin-testnet": VertcoinTestnet,
+	"dogecoin":         Dogecoin,
+	"regtest":          Regtest,
+}
+
+// ByName returns the network registered under name.
+func ByName(name string) (Network, error) {
+	n, ok := networks[strings.ToLower(name)]
+	if !ok {
+		return Network{}, fmt.Errorf("Unknown network %s (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return n(), nil
+}
+
+// Names returns the names of all registered networks in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(networks))
+	for n := range networks {
+		names = append(names, n)
 	}
-	return n
+	sort.Strings(names)
+	return names
+}
+
+// IsSegwitShare returns whether shares of the given version carry segwit data
+// on this network.
+func (n Network) IsSegwitShare(version uint64) bool {
+	return n.SegwitActivationVersion != 0 && version >= n.SegwitActivationVersion
+}
+
+// ValidateAddress checks that addr is a valid address on the parent chain of
+// this network.
+func (n Network) ValidateAddress(addr string) error {
+	if n.Bech32Prefix != "" && strings.HasPrefix(strings.ToLower(addr), n.Bech32Prefix+"1") {
+		hrp, _, err := bech32.Decode(addr)
+		if err != nil {
+			return err
+		}
+		if hrp != n.Bech32Prefix {
+			return fmt.Errorf("Address %s is not a %s address", addr, n.Name)
+		}
+		return nil
+	}
+
+	_, version, err := base58.CheckDecode(addr)
+	if err != nil {
+		return fmt.Errorf("Address %s is invalid: %s", addr, err.Error())
+	}
+	if version != n.AddressVersion && version != n.ScriptAddressVersion {
+		return fmt.Errorf("Address %s is not a %s address", addr, n.Name)
+	}
+	return nil
+}
+
+// defaultDonationScript is the donation output script used by the reference
+// implementation on all networks.
+const defaultDonationScript = "410418a74130b2f4fad899d8ed2bff272bc43a03c8ca72897ae3da584d7a770b5a9ea8dd1b37a620d27c6cf6d5a7a9bbd6872f5981e95816d701d94f201c5d093be6ac"
+
+// maxTarget returns 2^256 / 2^shift - 1
+func maxTarget(shift uint) *big.Int {
+	t := big.NewInt(1)
+	t.Lsh(t, 256-shift)
+	return t.Sub(t, big.NewInt(1))
+}
+
+func sha256dPOW(b []byte) []byte {
+	return util.Sha256d(b)
+}
+
+func scryptPOW(b []byte) []byte {
+	res, _ := scrypt.Key(b, b, 1024, 1, 1, 32)
+	return res
 }
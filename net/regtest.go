@@ -0,0 +1,35 @@
+package net
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+// Regtest is a network for local testing. Shares are SHA256d and accepted at
+// nearly any difficulty, and nodes never connect to seed hosts.
+func Regtest() Network {
+	n := Network{Name: "regtest", P2PPort: 19444}
+	n.MessagePrefix, _ = hex.DecodeString("7265677465737431")
+	n.Identifier, _ = hex.DecodeString("7265677465737432")
+	n.MinProtocolVersion = 1700
+	n.SharePeriod = 1
+	n.ChainLength = 100
+	n.RealChainLength = 100
+	n.TargetLookbehind = 10
+	n.Spread = 3
+	n.MinTarget = big.NewInt(0)
+	n.MaxTarget = maxTarget(1)
+	n.DonationScript, _ = hex.DecodeString(defaultDonationScript)
+	n.MinShareVersion = 16
+	n.SegwitActivationVersion = 17
+	n.SoftForks = []string{"bip65", "csv", "segwit"}
+	n.CoinbaseMaturity = 100
+	n.BlockPeriod = 600
+	n.AddressVersion = 111
+	n.ScriptAddressVersion = 196
+	n.Bech32Prefix = "bcrt"
+	n.Symbol = "rBTC"
+	n.SeedHosts = []string{}
+	n.POWHash = sha256dPOW
+	return n
+}
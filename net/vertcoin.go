@@ -0,0 +1,63 @@
+package net
+
+import (
+	"encoding/hex"
+	"math/big"
+
+	"github.com/adamcollier1/lyra2rev3"
+)
+
+func Vertcoin() Network {
+	n := Network{Name: "vertcoin", P2PPort: 9346}
+	n.MessagePrefix, _ = hex.DecodeString("7c3614a6bcdcf784")
+	n.Identifier, _ = hex.DecodeString("a06a81c827cab983")
+	n.MinProtocolVersion = 1700
+	n.SharePeriod = 15
+	n.ChainLength = 5100
+	n.RealChainLength = 5100
+	n.TargetLookbehind = 200
+	n.Spread = 3
+	n.MinTarget = big.NewInt(0)
+	n.MaxTarget = maxTarget(20)
+	n.DonationScript, _ = hex.DecodeString(defaultDonationScript)
+	n.MinShareVersion = 16
+	n.SegwitActivationVersion = 17
+	n.SoftForks = []string{"bip65", "csv", "segwit"}
+	n.CoinbaseMaturity = 100
+	n.BlockPeriod = 150
+	n.AddressVersion = 71
+	n.ScriptAddressVersion = 5
+	n.Bech32Prefix = "vtc"
+	n.Symbol = "VTC"
+	n.BlockExplorerURLPrefix = "https://insight.vertcoin.org/block/"
+	n.AddressExplorerURLPrefix = "https://insight.vertcoin.org/address/"
+	n.TxExplorerURLPrefix = "https://insight.vertcoin.org/tx/"
+	n.SeedHosts = []string{"localhost", "p2proxy.vertcoin.org", "vtc.alwayshashing.com", "crypto.office-on-the.net", "pool.vtconline.org"}
+	n.POWHash = lyra2rev3POW
+	return n
+}
+
+func VertcoinTestnet() Network {
+	n := Vertcoin()
+	n.Name = "vertcoin-testnet"
+	n.P2PPort = 19346
+	n.MessagePrefix, _ = hex.DecodeString("7c3614a6bcdcf794")
+	n.Identifier, _ = hex.DecodeString("a06a81c827cab993")
+	n.ChainLength = 400
+	n.RealChainLength = 400
+	n.MaxTarget = maxTarget(12)
+	n.AddressVersion = 74
+	n.ScriptAddressVersion = 196
+	n.Bech32Prefix = "tvtc"
+	n.Symbol = "tVTC"
+	n.BlockExplorerURLPrefix = ""
+	n.AddressExplorerURLPrefix = ""
+	n.TxExplorerURLPrefix = ""
+	n.SeedHosts = []string{"localhost"}
+	return n
+}
+
+func lyra2rev3POW(b []byte) []byte {
+	res, _ := lyra2rev3.SumV3(b)
+	return res
+}
@@ -0,0 +1,47 @@
+package p2p
+
+import (
+	"strings"
+
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// subVersionPrefix starts the sub version string of p2pool-go nodes
+const subVersionPrefix = "p2pool-go/"
+
+// Capabilities holds what a peer announced in its version message, so
+// features can be enabled only for peers that understand them.
+type Capabilities struct {
+	// Version is the protocol version the peer announced, Negotiated the
+	// version both sides speak: the lower of Version and ProtocolVersion.
+	Version    int32
+	Negotiated int32
+	SubVersion string
+	Services   int64
+	Mode       int32
+}
+
+func newCapabilities(v *wire.MsgVersion) Capabilities {
+	c := Capabilities{
+		Version:    v.Version,
+		Negotiated: v.Version,
+		SubVersion: v.SubVersion,
+		Services:   v.Services,
+		Mode:       v.Mode,
+	}
+	if c.Negotiated > ProtocolVersion {
+		c.Negotiated = ProtocolVersion
+	}
+	return c
+}
+
+// Supports returns true when the negotiated protocol version is at least
+// version, the protocol version a feature was introduced in.
+func (c Capabilities) Supports(version int32) bool {
+	return c.Negotiated >= version
+}
+
+// IsGo returns true when the peer runs p2pool-go
+func (c Capabilities) IsGo() bool {
+	return strings.HasPrefix(c.SubVersion, subVersionPrefix)
+}
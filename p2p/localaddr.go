@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+)
+
+// maxAddressVotes limits the number of peers whose view of our address is
+// remembered
+const maxAddressVotes = 1000
+
+// minAddressVotes is the number of peers that must agree on our address
+// before it is advertised, so a single peer cannot make us announce an
+// address of its choosing.
+const minAddressVotes = 3
+
+// LocalAddress is the address this node advertises to peers in the version
+// message. An address from the configuration always wins. Otherwise an
+// address discovered through port mapping on the router is used, and failing
+// that the address most peers report seeing us at. Looking it up never
+// blocks on the network. A hidden local address advertises nothing but the
+// configured address.
+type LocalAddress struct {
+	lock       sync.Mutex
+	hidden     bool
+	configured net.IP
+	discovered net.IP
+	port       int
+	votes      map[string]string // peer IP -> the IP that peer sees us at
+}
+
+// NewLocalAddress creates a local address advertising port, and ip when it is
+// not nil.
+func NewLocalAddress(ip net.IP, port int) *LocalAddress {
+	return &LocalAddress{configured: ip, port: port, votes: make(map[string]string)}
+}
+
+// Get returns the address to advertise. The IP is the unspecified address
+// when it is not known.
+func (l *LocalAddress) Get() (net.IP, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.configured != nil {
+		return l.configured, l.port
+	}
+	if l.hidden {
+		return net.IPv4zero, l.port
+	}
+	if l.discovered != nil {
+		return l.discovered, l.port
+	}
+	if ip := l.majority(); ip != nil {
+		return ip, l.port
+	}
+	return net.IPv4zero, l.port
+}
+
+// SetDiscovered sets the external address learned from the router. The port
+// is the external port mapped to ours, or 0 to keep the current port.
+func (l *LocalAddress) SetDiscovered(ip net.IP, port int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.discovered = ip
+	if port != 0 {
+		l.port = port
+	}
+}
+
+// Vote records the address peer reports seeing us at in its version message.
+// Only public addresses count, and every peer IP has a single vote.
+func (l *LocalAddress) Vote(peer, reported net.IP) {
+	if peer == nil || !isPublic(reported) {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.votes[peer.String()]; !ok && len(l.votes) >= maxAddressVotes {
+		return
+	}
+	l.votes[peer.String()] = reported.String()
+}
+
+// majority returns the address reported by most peers, or nil when fewer
+// than minAddressVotes peers agree on it. The caller must hold the lock.
+func (l *LocalAddress) majority() net.IP {
+	counts := make(map[string]int)
+	best, bestCount := "", 0
+	for _, ip := range l.votes {
+		counts[ip]++
+		if counts[ip] > bestCount || (counts[ip] == bestCount && ip < best) {
+			best, bestCount = ip, counts[ip]
+		}
+	}
+	if bestCount < minAddressVotes {
+		return nil
+	}
+	return net.ParseIP(best)
+}
+
+func isPublic(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
@@ -0,0 +1,61 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+)
+
+func peerIP(i int) net.IP {
+	return net.IPv4(198, 51, 100, byte(i))
+}
+
+func TestLocalAddressVotes(t *testing.T) {
+	l := NewLocalAddress(nil, 9333)
+	a := net.ParseIP("203.0.113.1")
+	b := net.ParseIP("203.0.113.2")
+
+	// A single peer cannot decide the address
+	l.Vote(peerIP(1), b)
+	if ip, _ := l.Get(); !ip.Equal(net.IPv4zero) {
+		t.Fatalf("Expected no address after one vote, got %s", ip)
+	}
+
+	// Private addresses and repeated votes of a peer do not count
+	l.Vote(peerIP(2), net.ParseIP("192.168.1.1"))
+	l.Vote(peerIP(3), net.ParseIP("10.0.0.1"))
+	for i := 0; i < 3; i++ {
+		l.Vote(peerIP(4), a)
+	}
+	if ip, _ := l.Get(); !ip.Equal(net.IPv4zero) {
+		t.Fatalf("Expected no address without enough agreeing peers, got %s", ip)
+	}
+
+	l.Vote(peerIP(5), a)
+	l.Vote(peerIP(6), a)
+	if ip, port := l.Get(); !ip.Equal(a) || port != 9333 {
+		t.Fatalf("Expected %s:9333, got %s:%d", a, ip, port)
+	}
+
+	// The address most peers report wins
+	for i := 7; i < 11; i++ {
+		l.Vote(peerIP(i), b)
+	}
+	if ip, _ := l.Get(); !ip.Equal(b) {
+		t.Fatalf("Expected the majority address %s, got %s", b, ip)
+	}
+
+	// The router and the configuration take precedence over the vote
+	discovered := net.ParseIP("203.0.113.3")
+	l.SetDiscovered(discovered, 0)
+	if ip, _ := l.Get(); !ip.Equal(discovered) {
+		t.Fatalf("Expected the discovered address %s, got %s", discovered, ip)
+	}
+	configured := net.ParseIP("203.0.113.4")
+	l = NewLocalAddress(configured, 9333)
+	for i := 1; i < 5; i++ {
+		l.Vote(peerIP(i), a)
+	}
+	if ip, _ := l.Get(); !ip.Equal(configured) {
+		t.Fatalf("Expected the configured address %s, got %s", configured, ip)
+	}
+}
@@ -0,0 +1,48 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+// LocalNode is what peers need to know about this node during the
+// handshake: the nonce that identifies it, the address it advertises and the
+// nonces of the peers it is connected to.
+type LocalNode struct {
+	Nonce   int64
+	Address *LocalAddress
+
+	lock  sync.Mutex
+	peers map[int64]bool
+}
+
+// NewLocalNode creates a node identity with a random nonce. The nonce comes
+// from crypto/rand, as an unseeded math/rand gives every process the same one
+// on older Go versions, which would make all nodes reject each other as self.
+func NewLocalNode(address *LocalAddress) *LocalNode {
+	l := &LocalNode{
+		Address: address,
+		peers:   make(map[int64]bool),
+	}
+	binary.Read(rand.Reader, binary.LittleEndian, &l.Nonce)
+	return l
+}
+
+// register records a connection to the peer with the given nonce. It returns
+// false when that peer is already connected.
+func (l *LocalNode) register(nonce int64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.peers[nonce] {
+		return false
+	}
+	l.peers[nonce] = true
+	return true
+}
+
+func (l *LocalNode) unregister(nonce int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.peers, nonce)
+}
@@ -0,0 +1,11 @@
+package p2p
+
+import "testing"
+
+func TestLocalNodeNonces(t *testing.T) {
+	a := NewLocalNode(nil)
+	b := NewLocalNode(nil)
+	if a.Nonce == 0 || a.Nonce == b.Nonce {
+		t.Fatalf("Expected distinct random nonces, got %d and %d", a.Nonce, b.Nonce)
+	}
+}
@@ -0,0 +1,5 @@
+package p2p
+
+import "github.com/gertjaap/p2pool-go/logging"
+
+var log = logging.New("p2p")
@@ -1,115 +1,405 @@
 package p2p
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	p2poolnet "github.com/gertjaap/p2pool-go/net"
 	"github.com/gertjaap/p2pool-go/util"
 	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
 )
 
+// Version of p2pool-go, advertised to peers and shown in the web interface
+const Version = "0.0.1"
+
+// ProtocolVersion is the version of the p2pool protocol this node speaks
+const ProtocolVersion = 1800
+
 type Peer struct {
 	Connection *wire.P2PoolConnection
+	// RemoteHost is the host the peer was dialed at, or its IP address for
+	// inbound peers. RemoteIP is nil when the host is a name, such as a
+	// .onion address.
+	RemoteHost string
 	RemoteIP   net.IP
 	RemotePort int
 	Network    p2poolnet.Network
+	Inbound    bool
 
+	ctx         context.Context
+	wg          sync.WaitGroup
 	newPeers    chan []wire.Addr
-	sharesChan  chan []wire.Share
+	shareChain  *work.ShareChain
+	local       *LocalNode
 	versionInfo *wire.MsgVersion
+	caps        Capabilities
+
+	rttLock     sync.Mutex
+	rtt         time.Duration
+	pendingReqs map[chainhash.Hash]time.Time
+
+	bestLock sync.Mutex
+	bestHash *chainhash.Hash
+	bestWork *big.Int
+}
+
+// PeerInfo describes a connected peer for the peer list API
+type PeerInfo struct {
+	Address       string  `json:"address"`
+	Inbound       bool    `json:"inbound"`
+	Version       int32   `json:"version"`
+	SubVersion    string  `json:"sub_version"`
+	ConnectedFor  float64 `json:"connected_for"`
+	LastReceived  float64 `json:"last_received"`
+	RTT           float64 `json:"rtt"`
+	QueueMessages int     `json:"queue_messages"`
+	QueueBytes    int     `json:"queue_bytes"`
+	BestShare     string  `json:"best_share"`
+}
+
+// NewPeer connects to the peer at host and port and performs the handshake.
+func NewPeer(ctx context.Context, host string, port int, n p2poolnet.Network, cfg wire.ConnConfig, local *LocalNode, sc *work.ShareChain, newPeers chan []wire.Addr, closed chan bool) (*Peer, error) {
+	if port == 0 {
+		port = n.P2PPort
+	}
+	dialStart := time.Now()
+	conn, err := wire.NewP2PoolClient(ctx, host, port, n, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &Peer{Connection: conn, RemoteHost: host, RemoteIP: net.ParseIP(host), RemotePort: port, local: local}
+	// Setting up the TCP connection takes one round trip
+	p.sampleRTT(time.Since(dialStart))
+	err = p.start(ctx, n, sc, newPeers, closed)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func NewPeer(ip net.IP, port int, n p2poolnet.Network, newPeers chan []wire.Addr, closed chan bool, sharesChan chan []wire.Share) (*Peer, error) {
-	p := Peer{Network: n, newPeers: newPeers, sharesChan: sharesChan}
-	p.RemoteIP = ip
-	var err error
-	p.Connection, err = wire.NewP2PoolClient(ip, port, n)
+// NewPeerFromConnection performs the handshake on an accepted inbound
+// connection.
+func NewPeerFromConnection(ctx context.Context, conn *wire.P2PoolConnection, n p2poolnet.Network, local *LocalNode, sc *work.ShareChain, newPeers chan []wire.Addr, closed chan bool) (*Peer, error) {
+	p := &Peer{Connection: conn, Inbound: true, local: local}
+	host, port, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err == nil {
+		p.RemoteHost = host
+		p.RemoteIP = net.ParseIP(host)
+		p.RemotePort, _ = strconv.Atoi(port)
+	}
+	err = p.start(ctx, n, sc, newPeers, closed)
 	if err != nil {
 		return nil, err
 	}
+	return p, nil
+}
+
+func (p *Peer) start(ctx context.Context, n p2poolnet.Network, sc *work.ShareChain, newPeers chan []wire.Addr, closed chan bool) error {
+	p.Network = n
+	p.ctx = ctx
+	p.shareChain = sc
+	p.newPeers = newPeers
+	p.pendingReqs = make(map[chainhash.Hash]time.Time)
 
-	err = p.Handshake()
+	err := p.Handshake()
 	if err != nil {
 		p.Connection.Close()
-		return nil, err
+		p.Connection.Wait()
+		return err
+	}
+
+	if best := p.versionInfo.BestShareHash; best != nil && !best.IsEqual(&chainhash.Hash{}) {
+		p.bestHash = best
 	}
 
+	p.wg.Add(3)
 	go func() {
+		defer p.wg.Done()
 		<-p.Connection.Disconnected
+		p.local.unregister(p.versionInfo.Nonce)
 		closed <- true
 	}()
 
 	go p.IncomingLoop()
 	go p.PingLoop()
 
-	return &p, nil
+	return nil
 }
 
+// BestShare returns the hash of the best share the peer is known to have:
+// the one with the most work among the shares it sent us, or the one it
+// announced in its version message. It returns nil when the peer has no
+// shares.
 func (p *Peer) BestShare() *chainhash.Hash {
-	return p.versionInfo.BestShareHash
+	p.bestLock.Lock()
+	defer p.bestLock.Unlock()
+	return p.bestHash
+}
+
+// BestWork returns the absolute work of the best share of the peer, or nil
+// when it is not known yet. That is the case until the peer has sent us its
+// best share.
+func (p *Peer) BestWork() *big.Int {
+	p.bestLock.Lock()
+	defer p.bestLock.Unlock()
+	return p.bestWork
+}
+
+// updateBest records the share with the most work among shares the peer sent
+func (p *Peer) updateBest(shares []wire.Share) {
+	p.bestLock.Lock()
+	defer p.bestLock.Unlock()
+	for _, s := range shares {
+		if s.Hash == nil || s.ShareInfo.AbsWork == nil {
+			continue
+		}
+		if p.bestHash != nil && s.Hash.IsEqual(p.bestHash) {
+			p.bestWork = s.ShareInfo.AbsWork
+			continue
+		}
+		if p.bestWork == nil || work.MoreWork(s.ShareInfo.AbsWork, p.bestWork) {
+			p.bestHash = s.Hash
+			p.bestWork = s.ShareInfo.AbsWork
+		}
+	}
 }
 
 func (p *Peer) PingLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.Connection.Config().PingInterval)
+	defer ticker.Stop()
 	for {
-		time.Sleep(time.Second * 15)
-		p.Connection.Outgoing <- &wire.MsgPing{}
+		select {
+		case <-ticker.C:
+			p.Connection.Send(&wire.MsgPing{})
+		case <-p.Connection.Done():
+			return
+		}
 	}
 }
 
 func (p *Peer) IncomingLoop() {
+	defer p.wg.Done()
+	defer close(p.newPeers)
 	for msg := range p.Connection.Incoming {
 		switch t := msg.(type) {
 		case *wire.MsgAddrs:
 			p.newPeers <- t.Addresses
 		case *wire.MsgShares:
-			p.sharesChan <- t.Shares
+			p.updateBest(t.Shares)
+			p.forwardShares(t.Shares)
 		case *wire.MsgShareReply:
-			p.sharesChan <- t.Shares
+			p.completeRequest(t.ID)
+			p.updateBest(t.Shares)
+			p.forwardShares(t.Shares)
+		case *wire.MsgShareReq:
+			p.handleShareReq(t)
+		}
+	}
+}
+
+func (p *Peer) forwardShares(shares []wire.Share) {
+	select {
+	case p.shareChain.SharesChannel <- shares:
+	case <-p.ctx.Done():
+	}
+}
+
+func (p *Peer) handleShareReq(req *wire.MsgShareReq) {
+	reply := &wire.MsgShareReply{Network: p.Network, ID: req.ID, Result: wire.MsgShareReplyResultGood}
+	if len(req.Hashes) > 0 {
+		parents := req.Parents
+		if max := uint64(1000 / len(req.Hashes)); parents > max {
+			parents = max
+		}
+		shares, err := p.shareChain.GetShares(req.Hashes, parents, req.Stops)
+		if err != nil {
+			log.Debugf("Cannot serve share request: %s", err.Error())
+			reply.Result = wire.MsgShareReplyResultUnk2
+		} else {
+			reply.Shares = shares
 		}
 	}
+	p.Connection.Send(reply)
+}
+
+// RequestShares asks the peer for the shares with the given hashes and up to
+// parents of their ancestors, stopping at any of the hashes in stops. The
+// time until the reply arrives is used to measure the round trip time.
+func (p *Peer) RequestShares(hashes []*chainhash.Hash, parents uint64, stops []*chainhash.Hash) {
+	id := util.GetRandomId()
+	now := time.Now()
+	p.rttLock.Lock()
+	for h, sent := range p.pendingReqs {
+		// Requests that are never answered must not pile up
+		if now.Sub(sent) > p.Connection.Config().ReadTimeout {
+			delete(p.pendingReqs, h)
+		}
+	}
+	p.pendingReqs[*id] = now
+	p.rttLock.Unlock()
+
+	p.Connection.Send(&wire.MsgShareReq{
+		ID:      id,
+		Parents: parents,
+		Stops:   stops,
+		Hashes:  hashes,
+	})
+}
+
+func (p *Peer) completeRequest(id *chainhash.Hash) {
+	if id == nil {
+		return
+	}
+	p.rttLock.Lock()
+	sent, ok := p.pendingReqs[*id]
+	delete(p.pendingReqs, *id)
+	p.rttLock.Unlock()
+	if ok {
+		p.sampleRTT(time.Since(sent))
+	}
+}
+
+// sampleRTT adds a round trip measurement to the smoothed round trip time,
+// weighing each new sample by 1/8 like TCP does.
+func (p *Peer) sampleRTT(d time.Duration) {
+	p.rttLock.Lock()
+	defer p.rttLock.Unlock()
+	if p.rtt == 0 {
+		p.rtt = d
+		return
+	}
+	p.rtt += (d - p.rtt) / 8
+}
+
+// RTT returns the smoothed round trip time to the peer, or 0 when it has not
+// been measured yet.
+func (p *Peer) RTT() time.Duration {
+	p.rttLock.Lock()
+	defer p.rttLock.Unlock()
+	return p.rtt
+}
+
+// Address returns the host:port of the peer
+func (p *Peer) Address() string {
+	return net.JoinHostPort(p.RemoteHost, strconv.Itoa(p.RemotePort))
+}
+
+// Info returns the state of the peer for the peer list API
+func (p *Peer) Info() PeerInfo {
+	now := time.Now()
+	info := PeerInfo{
+		Address:      p.Address(),
+		Inbound:      p.Inbound,
+		ConnectedFor: now.Sub(p.Connection.Connected()).Seconds(),
+		RTT:          p.RTT().Seconds(),
+	}
+	info.Version = p.caps.Version
+	info.SubVersion = p.caps.SubVersion
+	if last := p.Connection.LastReceived(); !last.IsZero() {
+		info.LastReceived = now.Sub(last).Seconds()
+	}
+	info.QueueMessages, info.QueueBytes = p.Connection.QueueLen()
+	if best := p.BestShare(); best != nil {
+		info.BestShare = best.String()
+	}
+	return info
 }
 
 func (p *Peer) AskNewAddresses(count int32) {
-	p.Connection.Outgoing <- &wire.MsgGetAddrs{
+	p.Connection.Send(&wire.MsgGetAddrs{
 		Count: count,
-	}
+	})
+}
+
+// Wait blocks until all goroutines belonging to this peer have exited.
+func (p *Peer) Wait() {
+	p.wg.Wait()
+	p.Connection.Wait()
+}
+
+// Capabilities returns what the peer announced in its version message
+func (p *Peer) Capabilities() Capabilities {
+	return p.caps
 }
 
+// Handshake exchanges version messages with the peer. An outbound peer is
+// sent our version first, an inbound peer only once its own version has been
+// accepted, so a connection is refused before anything about this node is
+// revealed.
 func (p *Peer) Handshake() error {
-	myIP, err := util.GetMyPublicIP()
+	if !p.Inbound {
+		p.sendVersion()
+	}
+	err := p.receiveVersion()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if !p.local.register(p.versionInfo.Nonce) {
+		return fmt.Errorf("Already connected to this peer")
 	}
-	p.Connection.Outgoing <- &wire.MsgVersion{
-		Version:  1800,
+	if p.Inbound {
+		p.sendVersion()
+	}
+	p.caps = newCapabilities(p.versionInfo)
+	p.local.Address.Vote(p.RemoteIP, p.versionInfo.AddrTo.Address)
+	log.Debug("Handshake complete", "peer", p.Connection.RemoteAddr(), "version", p.caps.Version, "sub_version", p.caps.SubVersion, "inbound", p.Inbound)
+	return nil
+}
+
+func (p *Peer) sendVersion() {
+	myIP, myPort := p.local.Address.Get()
+	p.Connection.Send(&wire.MsgVersion{
+		Version:  ProtocolVersion,
 		Services: 0,
 		AddrTo: wire.P2PoolAddress{
 			Services: 0,
 			Address:  p.RemoteIP,
-			Port:     int16(p.Network.P2PPort),
+			Port:     int16(p.RemotePort),
 		},
 		AddrFrom: wire.P2PoolAddress{
 			Services: 0,
 			Address:  myIP,
-			Port:     int16(p.Network.P2PPort),
+			Port:     int16(myPort),
 		},
-		Nonce:      int64(rand.Uint64()),
-		SubVersion: "p2pool-go/0.0.1",
-		Mode:       1,
-	}
+		Nonce:         p.local.Nonce,
+		SubVersion:    subVersionPrefix + Version,
+		Mode:          1,
+		BestShareHash: p.shareChain.GetTipHash(),
+	})
+}
+
+// receiveVersion waits for the version message of the peer and checks that
+// we can talk to it.
+func (p *Peer) receiveVersion() error {
 	select {
-	case msg := <-p.Connection.Incoming:
-		var ok bool
+	case msg, ok := <-p.Connection.Incoming:
+		if !ok {
+			return fmt.Errorf("Connection closed before receiving version message")
+		}
 		p.versionInfo, ok = msg.(*wire.MsgVersion)
 		if !ok {
 			return fmt.Errorf("First message received from peer was not version message")
 		}
 	case <-time.After(5 * time.Second):
 		return fmt.Errorf("Timeout waiting for version message from peer")
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+
+	v := p.versionInfo
+	if v.Nonce == p.local.Nonce {
+		return fmt.Errorf("Connected to self")
+	}
+	if v.Version < p.Network.MinProtocolVersion {
+		return fmt.Errorf("Peer protocol version %d (%s) is below the minimum version %d", v.Version, v.SubVersion, p.Network.MinProtocolVersion)
 	}
 	return nil
 }
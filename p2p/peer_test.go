@@ -0,0 +1,77 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+func TestSampleRTT(t *testing.T) {
+	p := &Peer{}
+	if p.RTT() != 0 {
+		t.Fatalf("Expected no round trip time before a sample, got %s", p.RTT())
+	}
+	p.sampleRTT(100 * time.Millisecond)
+	if p.RTT() != 100*time.Millisecond {
+		t.Fatalf("Expected the first sample to be taken as is, got %s", p.RTT())
+	}
+	p.sampleRTT(900 * time.Millisecond)
+	if p.RTT() != 200*time.Millisecond {
+		t.Fatalf("Expected a new sample to count for 1/8, got %s", p.RTT())
+	}
+}
+
+func TestShareRequestRTT(t *testing.T) {
+	n := p2pnet.Regtest()
+	local, remote := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := wire.NewP2PoolConnection(ctx, local, n, wire.ConnConfig{})
+	defer func() {
+		cancel()
+		remote.Close()
+		conn.Wait()
+	}()
+	p := &Peer{Connection: conn, pendingReqs: make(map[chainhash.Hash]time.Time)}
+
+	p.RequestShares(nil, 0, nil)
+	raw, err := wire.ReadRawMessage(remote, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wire.ParseMessage(n, raw.Command, raw.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, ok := msg.(*wire.MsgShareReq)
+	if !ok {
+		t.Fatalf("Expected a share request, got %s", raw.Command)
+	}
+
+	// A reply to an unknown request is not a sample
+	p.completeRequest(&chainhash.Hash{1})
+	if p.RTT() != 0 {
+		t.Fatalf("Expected no round trip time, got %s", p.RTT())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	p.completeRequest(req.ID)
+	if p.RTT() < 20*time.Millisecond || p.RTT() > time.Second {
+		t.Fatalf("Expected a round trip time of about 20ms, got %s", p.RTT())
+	}
+	if len(p.pendingReqs) != 0 {
+		t.Fatalf("Expected the request to be completed, %d still pending", len(p.pendingReqs))
+	}
+
+	// A second reply to the same request is not counted again
+	rtt := p.RTT()
+	time.Sleep(20 * time.Millisecond)
+	p.completeRequest(req.ID)
+	if p.RTT() != rtt {
+		t.Fatalf("Expected the round trip time to stay %s, got %s", rtt, p.RTT())
+	}
+}
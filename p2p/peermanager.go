@@ -1,30 +1,64 @@
 package p2p
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/gertjaap/p2pool-go/util"
+	"github.com/gertjaap/p2pool-go/config"
 	"github.com/gertjaap/p2pool-go/work"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/metrics"
 	p2poolnet "github.com/gertjaap/p2pool-go/net"
 	"github.com/gertjaap/p2pool-go/wire"
 )
 
+const addrsFile = "addrs.dat"
+
+// maxAddresses is the size of the address book. When it is full the
+// addresses seen longest ago are dropped.
+const maxAddresses = 1000
+
+// Peers dialed by name are retried after namedPeerRetry when they cannot be
+// reached, doubling the delay after every failure up to maxNamedPeerRetry.
+const (
+	namedPeerRetry    = 10 * time.Second
+	maxNamedPeerRetry = 10 * time.Minute
+)
+
 type PeerManager struct {
 	Network           p2poolnet.Network
 	peers             []*Peer
 	possiblePeers     []wire.Addr
+	namedPeers        []*namedPeer
 	shareChain        *work.ShareChain
 	askSharesChan     chan *chainhash.Hash
+	syncChan          chan struct{}
 	peersLock         sync.Mutex
 	possiblePeersLock sync.Mutex
+
+	ctx        context.Context
+	wg         sync.WaitGroup
+	listener   *wire.P2PoolListener
+	dataDir    string
+	minPeers   int
+	maxPeers   int
+	connConfig wire.ConnConfig
+	local      *LocalNode
 }
 
-func NewPeerManager(n p2poolnet.Network, sc *work.ShareChain) *PeerManager {
+// NewPeerManager creates a peer manager and starts connecting to peers. All
+// peers are disconnected and the address book is saved when ctx is cancelled;
+// use Wait to block until that has completed.
+func NewPeerManager(ctx context.Context, cfg *config.Config, n p2poolnet.Network, sc *work.ShareChain) *PeerManager {
 	p := &PeerManager{
 		Network:           n,
 		peers:             make([]*Peer, 0),
@@ -33,9 +67,41 @@ func NewPeerManager(n p2poolnet.Network, sc *work.ShareChain) *PeerManager {
 		possiblePeersLock: sync.Mutex{},
 		shareChain:        sc,
 		askSharesChan:     make(chan *chainhash.Hash, 100),
+		syncChan:          make(chan struct{}, 1),
+		ctx:               ctx,
+		dataDir:           cfg.NetworkDataDir(),
+		minPeers:          cfg.MinPeers,
+		maxPeers:          cfg.MaxPeers,
+		connConfig: wire.ConnConfig{
+			CaptureDir:    cfg.CaptureDirPath(),
+			PingInterval:  cfg.PingInterval,
+			ReadTimeout:   time.Duration(cfg.IdlePings) * cfg.PingInterval,
+			WriteTimeout:  cfg.WriteTimeout,
+			MaxQueueBytes: cfg.SendQueueSize * 1024 * 1024,
+			Proxy:         cfg.Proxy,
+			ProxyOnly:     cfg.ProxyOnly,
+		},
+	}
+	// Validate has made sure the configured IP parses
+	addr := NewLocalAddress(net.ParseIP(cfg.ExternalIP), n.P2PPort)
+	addr.hidden = cfg.ProxyOnly
+	p.local = NewLocalNode(addr)
+	for _, named := range cfg.PeerList() {
+		p.namedPeers = append(p.namedPeers, &namedPeer{address: named})
+	}
+
+	err := p.LoadAddresses()
+	if err != nil {
+		log.Warnf("Could not load address book: %s", err.Error())
 	}
 
 	for _, h := range n.SeedHosts {
+		if cfg.ProxyOnly {
+			// Resolving the seed hosts here would leak DNS queries, the
+			// proxy resolves them instead
+			p.namedPeers = append(p.namedPeers, &namedPeer{address: net.JoinHostPort(h, strconv.Itoa(n.P2PPort))})
+			continue
+		}
 		addrs, err := net.LookupIP(h)
 		if err == nil {
 			a := wire.Addr{
@@ -44,71 +110,132 @@ func NewPeerManager(n p2poolnet.Network, sc *work.ShareChain) *PeerManager {
 					Port:    int16(n.P2PPort),
 				},
 			}
-			p.possiblePeers = append(p.possiblePeers, a)
+			p.addPossiblePeers([]wire.Addr{a})
 		}
 	}
+	p.wg.Add(3)
 	go p.MonitorPeerCount()
 	go p.ShareAskLoop()
+	go p.SyncLoop()
 	return p
 }
 
+// Wait blocks until all peers have been disconnected and every goroutine
+// started by the peer manager has exited.
+func (p *PeerManager) Wait() {
+	p.wg.Wait()
+}
+
 func (p *PeerManager) MonitorPeerCount() {
+	defer p.wg.Done()
+	defer func() {
+		err := p.SaveAddresses()
+		if err != nil {
+			log.Errorf("Could not save address book: %s", err.Error())
+		}
+	}()
+
 	for {
-		if len(p.peers) > 0 {
-			time.Sleep(time.Second * 10)
+		if p.GetPeerCount() > 0 {
+			select {
+			case <-time.After(time.Second * 10):
+			case <-p.ctx.Done():
+				return
+			}
 		}
-		for len(p.peers) < 1 {
+		for p.GetPeerCount() < p.minPeers {
+			if p.ctx.Err() != nil {
+				return
+			}
+			if named := p.getNamedPeer(); named != "" {
+				log.Debugf("Trying peer %s", named)
+				err := p.AddPeerByAddress(named)
+				if err != nil {
+					retry := p.namedPeerFailed(named)
+					log.Warnf("Peer %s failed, retrying in %s: %s", named, retry, err.Error())
+				} else {
+					p.namedPeerConnected(named)
+				}
+				continue
+			}
 			tryPeer := p.GetPossiblePeer()
 			if tryPeer.Timestamp == -1 {
-				logging.Debugf("Not enough peers, and no possible peers to try. Asking existing peers for new peers")
+				log.Debugf("Not enough peers, and no possible peers to try. Asking existing peers for new peers")
 				// No peers left to try. Ask for more.
-				for _, peer := range p.peers {
+				for _, peer := range p.getPeers() {
 					peer.AskNewAddresses(10)
 				}
+				select {
+				case <-time.After(time.Second * 1):
+				case <-p.ctx.Done():
+					return
+				}
 				break
 			}
 			peerAddress := tryPeer.Address.Address
-			logging.Debugf("Trying peer %s", peerAddress.String())
+			log.Debugf("Trying peer %s", peerAddress.String())
 
 			err := p.AddPeerWithPort(peerAddress, int(tryPeer.Address.Port))
 			if err != nil {
-				logging.Warnf("Peer %s failed: %s", peerAddress.String(), err.Error())
+				log.Warnf("Peer %s failed: %s", peerAddress.String(), err.Error())
 				p.RemovePossiblePeer(tryPeer)
 			}
 		}
 	}
 }
 
+// ShareAskLoop requests shares the share chain needs from the peer with the
+// most work. When the same share is needed again, the peer did not have it
+// and the next peer is asked.
 func (p *PeerManager) ShareAskLoop() {
+	defer p.wg.Done()
+	askCounts := make(map[chainhash.Hash]int)
 	for {
-		if len(p.peers) > 0 {
-			h := <-p.askSharesChan
-			for _, pr := range p.peers {
+		if p.GetPeerCount() > 0 {
+			var h *chainhash.Hash
+			select {
+			case h = <-p.askSharesChan:
+			case h = <-p.shareChain.NeedShareChannel:
+			case <-p.ctx.Done():
+				return
+			}
+			peers := p.peersByWork()
+			if len(peers) > 0 {
+				if len(askCounts) >= maxAskCounts {
+					askCounts = make(map[chainhash.Hash]int)
+				}
+				pr := peers[askCounts[*h]%len(peers)].peer
+				askCounts[*h]++
 				stops := make([]*chainhash.Hash, 0)
 				tip := p.shareChain.GetTipHash()
 				if tip != nil {
 					stops = append(stops, tip)
 				}
-				pr.Connection.Outgoing <- &wire.MsgShareReq{
-					ID:      util.GetRandomId(),
-					Parents: 1000,
-					Stops:   stops,
-					Hashes:  []*chainhash.Hash{h},
-				}
+				pr.RequestShares([]*chainhash.Hash{h}, 1000, stops)
 			}
 		}
-		time.Sleep(time.Second * 1)
+		select {
+		case <-time.After(time.Second * 1):
+		case <-p.ctx.Done():
+			return
+		}
 	}
 }
 
 func (p *PeerManager) AskForShare(h *chainhash.Hash) {
-	p.askSharesChan <- h
+	select {
+	case p.askSharesChan <- h:
+	case <-p.ctx.Done():
+	}
 }
 
 func (p *PeerManager) GetPossiblePeer() wire.Addr {
+	p.possiblePeersLock.Lock()
+	defer p.possiblePeersLock.Unlock()
+	peers := p.getPeers()
 	for _, pos := range p.possiblePeers {
 		alreadyAPeer := false
-		for _, pr := range p.peers {
+		for _, pr := range peers {
 			if pr.RemoteIP.String() == pos.Address.Address.String() {
 				alreadyAPeer = true
 				break
@@ -121,6 +248,75 @@ func (p *PeerManager) GetPossiblePeer() wire.Addr {
 	return wire.Addr{Timestamp: -1}
 }
 
+// namedPeer is a peer from the configuration, or a seed host in proxy-only
+// mode, that is dialed by its host:port. Named peers are never forgotten,
+// only retried less often while they cannot be reached.
+type namedPeer struct {
+	address     string
+	failures    int
+	nextAttempt time.Time
+}
+
+// getNamedPeer returns a host:port from the named peers that is not
+// connected yet and is due to be tried, or an empty string if there is none.
+func (p *PeerManager) getNamedPeer() string {
+	p.possiblePeersLock.Lock()
+	defer p.possiblePeersLock.Unlock()
+	peers := p.getPeers()
+	now := time.Now()
+	for _, named := range p.namedPeers {
+		if now.Before(named.nextAttempt) {
+			continue
+		}
+		alreadyAPeer := false
+		for _, pr := range peers {
+			if pr.Address() == named.address {
+				alreadyAPeer = true
+				break
+			}
+		}
+		if !alreadyAPeer {
+			return named.address
+		}
+	}
+	return ""
+}
+
+// namedPeerFailed postpones the next attempt to connect to the named peer at
+// address and returns the delay.
+func (p *PeerManager) namedPeerFailed(address string) time.Duration {
+	p.possiblePeersLock.Lock()
+	defer p.possiblePeersLock.Unlock()
+	for _, named := range p.namedPeers {
+		if named.address != address {
+			continue
+		}
+		retry := maxNamedPeerRetry
+		if named.failures < 10 {
+			retry = namedPeerRetry << uint(named.failures)
+		}
+		if retry > maxNamedPeerRetry {
+			retry = maxNamedPeerRetry
+		}
+		named.failures++
+		named.nextAttempt = time.Now().Add(retry)
+		return retry
+	}
+	return 0
+}
+
+// namedPeerConnected resets the retry delay of the named peer at address
+func (p *PeerManager) namedPeerConnected(address string) {
+	p.possiblePeersLock.Lock()
+	defer p.possiblePeersLock.Unlock()
+	for _, named := range p.namedPeers {
+		if named.address == address {
+			named.failures = 0
+			named.nextAttempt = time.Time{}
+		}
+	}
+}
+
 func (p *PeerManager) RemovePossiblePeer(addr wire.Addr) {
 	p.possiblePeersLock.Lock()
 	newPossiblePeers := make([]wire.Addr, 0)
@@ -133,55 +329,218 @@ func (p *PeerManager) RemovePossiblePeer(addr wire.Addr) {
 	p.possiblePeersLock.Unlock()
 }
 
+// addPossiblePeers adds addrs to the address book. An address that is
+// already known keeps the newest timestamp, and only the maxAddresses most
+// recently seen addresses are kept.
+func (p *PeerManager) addPossiblePeers(addrs []wire.Addr) {
+	p.possiblePeersLock.Lock()
+	defer p.possiblePeersLock.Unlock()
+
+	index := make(map[string]int, len(p.possiblePeers))
+	for i, a := range p.possiblePeers {
+		index[addrKey(a)] = i
+	}
+	for _, a := range addrs {
+		key := addrKey(a)
+		if i, ok := index[key]; ok {
+			if a.Timestamp > p.possiblePeers[i].Timestamp {
+				p.possiblePeers[i].Timestamp = a.Timestamp
+			}
+			continue
+		}
+		index[key] = len(p.possiblePeers)
+		p.possiblePeers = append(p.possiblePeers, a)
+	}
+
+	if len(p.possiblePeers) > maxAddresses {
+		sort.SliceStable(p.possiblePeers, func(i, j int) bool {
+			return p.possiblePeers[i].Timestamp > p.possiblePeers[j].Timestamp
+		})
+		p.possiblePeers = p.possiblePeers[:maxAddresses]
+	}
+}
+
+func addrKey(a wire.Addr) string {
+	return net.JoinHostPort(a.Address.Address.String(), strconv.Itoa(int(uint16(a.Address.Port))))
+}
+
+// LoadAddresses reads the address book saved by SaveAddresses, if present.
+func (p *PeerManager) LoadAddresses() error {
+	b, err := ioutil.ReadFile(filepath.Join(p.dataDir, addrsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := &wire.MsgAddrs{}
+	err = msg.FromBytes(b)
+	if err != nil {
+		return err
+	}
+
+	p.addPossiblePeers(msg.Addresses)
+
+	log.Debugf("Loaded %d addresses from disk", len(msg.Addresses))
+	return nil
+}
+
+// SaveAddresses writes the known peer addresses to disk, using the same
+// encoding as the addrs message.
+func (p *PeerManager) SaveAddresses() error {
+	p.possiblePeersLock.Lock()
+	msg := &wire.MsgAddrs{Addresses: make([]wire.Addr, len(p.possiblePeers))}
+	copy(msg.Addresses, p.possiblePeers)
+	p.possiblePeersLock.Unlock()
+
+	b, err := msg.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(p.dataDir, 0700)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(p.dataDir, addrsFile)
+	err = ioutil.WriteFile(file+".new", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".new", file)
+}
+
 func (p *PeerManager) AddPeer(ip net.IP) error {
 	return p.AddPeerWithPort(ip, 0)
 }
 
 func (p *PeerManager) AddPeerWithPort(ip net.IP, port int) error {
+	return p.addOutboundPeer(ip.String(), port)
+}
+
+// AddPeerByAddress connects to the peer at addr, a host:port where the host
+// is an IP address or a host name such as a .onion address.
+func (p *PeerManager) AddPeerByAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("Invalid port in %s", addr)
+	}
+	return p.addOutboundPeer(host, portNum)
+}
+
+func (p *PeerManager) addOutboundPeer(host string, port int) error {
+	if p.GetPeerCount() >= p.maxPeers {
+		return fmt.Errorf("Maximum number of peers (%d) reached", p.maxPeers)
+	}
 	newPeers := make(chan []wire.Addr, 10)
 	closed := make(chan bool, 1)
-	peer, err := NewPeer(ip, port, p.Network, newPeers, closed, p.shareChain.SharesChannel)
+	peer, err := NewPeer(p.ctx, host, port, p.Network, p.connConfig, p.local, p.shareChain, newPeers, closed)
 	if err != nil {
 		return err
 	}
-	p.peersLock.Lock()
-	p.peers = append(p.peers, peer)
-	p.peersLock.Unlock()
+	p.addPeer(peer, newPeers, closed)
+	return nil
+}
 
-	stops := make([]*chainhash.Hash, 0)
-	tip := p.shareChain.GetTipHash()
-	skipAsk := false
-	if tip != nil {
-		stops = append(stops, tip)
-		if tip.IsEqual(peer.versionInfo.BestShareHash) {
-			skipAsk = true
-		}
+// Listen starts accepting inbound peer connections on port. Use port 0 to
+// pick a free port, and ListenAddr to find out which one that was.
+func (p *PeerManager) Listen(port int) error {
+	l, err := wire.NewP2PoolListener(p.ctx, port, p.Network, p.connConfig)
+	if err != nil {
+		return err
 	}
+	p.listener = l
+	log.Infof("Listening for peers on %s", l.Addr().String())
+
+	p.wg.Add(1)
+	go p.AcceptLoop(l)
+	return nil
+}
+
+// LocalAddress returns the address advertised to peers
+func (p *PeerManager) LocalAddress() *LocalAddress {
+	return p.local.Address
+}
 
-	if !skipAsk {
-		peer.Connection.Outgoing <- &wire.MsgShareReq{
-			ID:      util.GetRandomId(),
-			Parents: 1000,
-			Stops:   stops,
-			Hashes:  []*chainhash.Hash{peer.versionInfo.BestShareHash},
+// ListenAddr returns the address inbound peers can connect to, or nil when
+// not listening.
+func (p *PeerManager) ListenAddr() net.Addr {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+func (p *PeerManager) AcceptLoop(l *wire.P2PoolListener) {
+	defer p.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.ctx.Err() == nil {
+				log.Errorf("Error accepting peer connection: %s", err.Error())
+			}
+			return
 		}
+
+		if p.GetPeerCount() >= p.maxPeers {
+			log.Debugf("Rejecting inbound peer %s: maximum number of peers reached", conn.RemoteAddr().String())
+			conn.Close()
+			conn.Wait()
+			continue
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			newPeers := make(chan []wire.Addr, 10)
+			closed := make(chan bool, 1)
+			peer, err := NewPeerFromConnection(p.ctx, conn, p.Network, p.local, p.shareChain, newPeers, closed)
+			if err != nil {
+				log.Warnf("Inbound peer %s failed: %s", conn.RemoteAddr().String(), err.Error())
+				return
+			}
+			p.addPeer(peer, newPeers, closed)
+		}()
 	}
+}
+
+func (p *PeerManager) addPeer(peer *Peer, newPeers chan []wire.Addr, closed chan bool) {
+	p.peersLock.Lock()
+	p.peers = append(p.peers, peer)
+	p.peersLock.Unlock()
+	p.updatePeerMetrics()
 
+	p.triggerSync()
+
+	p.wg.Add(2)
 	go p.NewPeersHandler(newPeers)
 	go p.ClosedHandler(peer, closed)
-	return nil
+}
+
+// BroadcastShares sends shares to all connected peers
+func (p *PeerManager) BroadcastShares(shares []wire.Share) {
+	for _, pr := range p.getPeers() {
+		pr.Connection.Send(&wire.MsgShares{
+			Network: p.Network,
+			Shares:  shares,
+		})
+	}
 }
 
 func (p *PeerManager) NewPeersHandler(c chan []wire.Addr) {
+	defer p.wg.Done()
 	for a := range c {
-		p.possiblePeersLock.Lock()
-		p.possiblePeers = append(p.possiblePeers, a...)
-		p.possiblePeersLock.Unlock()
+		p.addPossiblePeers(a)
 	}
-
 }
 
 func (p *PeerManager) ClosedHandler(peer *Peer, c chan bool) {
+	defer p.wg.Done()
 	<-c
 	p.peersLock.Lock()
 	newPeers := make([]*Peer, 0)
@@ -192,8 +551,58 @@ func (p *PeerManager) ClosedHandler(peer *Peer, c chan bool) {
 	}
 	p.peers = newPeers
 	p.peersLock.Unlock()
+	p.updatePeerMetrics()
+	peer.Wait()
+}
+
+func (p *PeerManager) getPeers() []*Peer {
+	p.peersLock.Lock()
+	defer p.peersLock.Unlock()
+	peers := make([]*Peer, len(p.peers))
+	copy(peers, p.peers)
+	return peers
+}
+
+// GetPeerCounts returns the number of inbound and outbound peers
+func (p *PeerManager) GetPeerCounts() (inbound int, outbound int) {
+	for _, pr := range p.getPeers() {
+		if pr.Inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+	return inbound, outbound
+}
+
+func (p *PeerManager) updatePeerMetrics() {
+	inbound, outbound := p.GetPeerCounts()
+	metrics.Peers.WithLabelValues("in").Set(float64(inbound))
+	metrics.Peers.WithLabelValues("out").Set(float64(outbound))
+}
+
+// GetPeerAddresses returns the host:port of every connected peer
+func (p *PeerManager) GetPeerAddresses() []string {
+	peers := p.getPeers()
+	addrs := make([]string, len(peers))
+	for i, pr := range peers {
+		addrs[i] = pr.Address()
+	}
+	return addrs
+}
+
+// GetPeerInfos returns the state of every connected peer
+func (p *PeerManager) GetPeerInfos() []PeerInfo {
+	peers := p.getPeers()
+	infos := make([]PeerInfo, len(peers))
+	for i, pr := range peers {
+		infos[i] = pr.Info()
+	}
+	return infos
 }
 
 func (p *PeerManager) GetPeerCount() int {
+	p.peersLock.Lock()
+	defer p.peersLock.Unlock()
 	return len(p.peers)
 }
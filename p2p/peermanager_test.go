@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+func testAddr(i int, port int16, timestamp int64) wire.Addr {
+	return wire.Addr{
+		Timestamp: timestamp,
+		Address: wire.P2PoolAddress{
+			Address: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)),
+			Port:    port,
+		},
+	}
+}
+
+func TestAddressBookDeduplicates(t *testing.T) {
+	dir := t.TempDir()
+	addrs := []wire.Addr{testAddr(1, 9346, 100), testAddr(1, 9346, 200), testAddr(1, 9347, 50), testAddr(2, 9346, 10)}
+
+	// Every restart loads the saved book and hears of the same addresses
+	// again, which must not grow the book
+	for i := 0; i < 3; i++ {
+		p := &PeerManager{dataDir: dir}
+		err := p.LoadAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.addPossiblePeers(addrs)
+		if len(p.possiblePeers) != 3 {
+			t.Fatalf("Expected 3 addresses after start %d, got %d", i, len(p.possiblePeers))
+		}
+		if p.possiblePeers[0].Timestamp != 200 {
+			t.Errorf("Expected the newest timestamp kept, got %d", p.possiblePeers[0].Timestamp)
+		}
+		err = p.SaveAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAddressBookLimit(t *testing.T) {
+	p := &PeerManager{}
+	addrs := make([]wire.Addr, 0)
+	for i := 0; i < maxAddresses+100; i++ {
+		addrs = append(addrs, testAddr(i, 9346, int64(i)))
+	}
+	p.addPossiblePeers(addrs)
+	if len(p.possiblePeers) != maxAddresses {
+		t.Fatalf("Expected %d addresses, got %d", maxAddresses, len(p.possiblePeers))
+	}
+	for _, a := range p.possiblePeers {
+		if a.Timestamp < 100 {
+			t.Fatalf("Expected the oldest addresses dropped, found timestamp %d", a.Timestamp)
+		}
+	}
+}
+
+func TestNamedPeerRetry(t *testing.T) {
+	a, b := "abcdefghijklmnop.onion:9333", "seed.example.com:9333"
+	p := &PeerManager{namedPeers: []*namedPeer{{address: a}, {address: b}}}
+
+	if named := p.getNamedPeer(); named != a {
+		t.Fatalf("Expected %s, got %q", a, named)
+	}
+	if retry := p.namedPeerFailed(a); retry != namedPeerRetry {
+		t.Fatalf("Expected a retry in %s, got %s", namedPeerRetry, retry)
+	}
+	if named := p.getNamedPeer(); named != b {
+		t.Fatalf("Expected %s while %s waits, got %q", b, a, named)
+	}
+	p.namedPeerFailed(b)
+	if named := p.getNamedPeer(); named != "" {
+		t.Fatalf("Expected no peer due, got %q", named)
+	}
+
+	// Failed peers are kept, with a delay that doubles up to the maximum
+	if retry := p.namedPeerFailed(a); retry != 2*namedPeerRetry {
+		t.Fatalf("Expected a retry in %s, got %s", 2*namedPeerRetry, retry)
+	}
+	for i := 0; i < 100; i++ {
+		p.namedPeerFailed(a)
+	}
+	if retry := p.namedPeerFailed(a); retry != maxNamedPeerRetry {
+		t.Fatalf("Expected a retry in %s, got %s", maxNamedPeerRetry, retry)
+	}
+	if len(p.namedPeers) != 2 {
+		t.Fatalf("Expected both named peers kept, got %d", len(p.namedPeers))
+	}
+
+	// Once due the peer is tried again, and connecting resets the delay
+	p.namedPeers[0].nextAttempt = time.Now().Add(-time.Second)
+	if named := p.getNamedPeer(); named != a {
+		t.Fatalf("Expected %s to be due, got %q", a, named)
+	}
+	p.namedPeerConnected(a)
+	if retry := p.namedPeerFailed(a); retry != namedPeerRetry {
+		t.Fatalf("Expected the delay reset to %s, got %s", namedPeerRetry, retry)
+	}
+}
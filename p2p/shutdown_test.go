@@ -0,0 +1,69 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/config"
+	"github.com/gertjaap/p2pool-go/internal/testutil"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+func startNode(t *testing.T, ctx context.Context, n p2pnet.Network) (*PeerManager, *work.ShareChain) {
+	cfg := config.Default()
+	cfg.Network = n.Name
+	cfg.DataDir = t.TempDir()
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := work.NewShareChain(ctx, n, cfg.NetworkDataDir())
+	pm := NewPeerManager(ctx, &cfg, n, sc)
+	err = pm.Listen(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm, sc
+}
+
+func TestShutdownLeavesNoGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	pmA, scA := startNode(t, ctx, n)
+	pmB, scB := startNode(t, ctx, n)
+
+	err := pmA.AddPeerWithPort(net.ParseIP("127.0.0.1"), pmB.ListenAddr().(*net.TCPAddr).Port)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	connected := testutil.WaitUntil(5*time.Second, func() bool {
+		return pmA.GetPeerCount() == 1 && pmB.GetPeerCount() == 1
+	})
+	if !connected {
+		cancel()
+		t.Fatalf("Peers did not connect: %d and %d peers", pmA.GetPeerCount(), pmB.GetPeerCount())
+	}
+	if runtime.NumGoroutine() <= baseline {
+		t.Fatalf("Expected running nodes to have goroutines, have %d, baseline %d", runtime.NumGoroutine(), baseline)
+	}
+
+	cancel()
+	pmA.Wait()
+	pmB.Wait()
+	scA.Wait()
+	scB.Wait()
+
+	// Goroutines that returned may take a moment to be accounted for
+	if !testutil.WaitUntil(5*time.Second, func() bool { return runtime.NumGoroutine() <= baseline }) {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		t.Fatalf("%d goroutines left after shutdown, baseline %d:\n%s", runtime.NumGoroutine(), baseline, buf)
+	}
+}
@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+const (
+	// syncInterval is how often the peers are checked for a better chain
+	syncInterval = 2 * time.Second
+	// syncRetry is how long to wait for a requested share before asking
+	// for it again
+	syncRetry = 10 * time.Second
+	// maxAskCounts bounds the number of needed shares ShareAskLoop keeps
+	// track of
+	maxAskCounts = 1000
+)
+
+// SyncLoop requests the best share of every peer whose chain has more work
+// than ours, starting with the peer that has the most work. Peers that
+// announced a share we do not know the work of are asked as well: their
+// chain may be better, and it is the only way to find out.
+func (p *PeerManager) SyncLoop() {
+	defer p.wg.Done()
+	requested := make(map[chainhash.Hash]time.Time)
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.syncChan:
+		case <-p.ctx.Done():
+			return
+		}
+		p.sync(requested)
+	}
+}
+
+// triggerSync makes SyncLoop check the peers right away
+func (p *PeerManager) triggerSync() {
+	select {
+	case p.syncChan <- struct{}{}:
+	default:
+	}
+}
+
+func (p *PeerManager) sync(requested map[chainhash.Hash]time.Time) {
+	now := time.Now()
+	for h, t := range requested {
+		if now.Sub(t) > syncRetry {
+			delete(requested, h)
+		}
+	}
+
+	tip := p.shareChain.GetTipHash()
+	var tipWork *big.Int
+	if tip != nil {
+		tipWork = p.shareChain.GetWork(tip)
+	}
+	for _, pw := range p.peersByWork() {
+		best := pw.peer.BestShare()
+		if best == nil || p.shareChain.GetShare(best) != nil {
+			continue
+		}
+		if pw.work != nil && tipWork != nil && !work.MoreWork(pw.work, tipWork) {
+			continue
+		}
+		if _, ok := requested[*best]; ok {
+			continue
+		}
+		requested[*best] = now
+
+		stops := make([]*chainhash.Hash, 0)
+		if tip != nil {
+			stops = append(stops, tip)
+		}
+		log.Debug("Requesting best share of peer", "peer", pw.peer.Connection.RemoteAddr(), "share", best.String())
+		pw.peer.RequestShares([]*chainhash.Hash{best}, 1000, stops)
+	}
+}
+
+type peerWork struct {
+	peer *Peer
+	work *big.Int
+}
+
+// peersByWork returns the connected peers ordered by the work of their best
+// share, most work first. Peers of which the work is not known come last.
+func (p *PeerManager) peersByWork() []peerWork {
+	peers := p.getPeers()
+	pws := make([]peerWork, 0, len(peers))
+	for _, pr := range peers {
+		w := pr.BestWork()
+		if w == nil {
+			if best := pr.BestShare(); best != nil {
+				w = p.shareChain.GetWork(best)
+			}
+		}
+		pws = append(pws, peerWork{peer: pr, work: w})
+	}
+	sort.SliceStable(pws, func(i, j int) bool {
+		if pws[j].work == nil {
+			return pws[i].work != nil
+		}
+		return pws[i].work != nil && work.MoreWork(pws[i].work, pws[j].work)
+	})
+	return pws
+}
@@ -0,0 +1,65 @@
+package p2p
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+func TestPeersByWork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := work.NewShareChain(ctx, p2pnet.Regtest(), t.TempDir())
+	defer func() {
+		cancel()
+		sc.Wait()
+	}()
+
+	// A share we have, of which the peer only announced the hash
+	known := &chainhash.Hash{1}
+	notBlock := chainhash.Hash{}
+	for i := range notBlock {
+		notBlock[i] = 0xff
+	}
+	sc.AddChainShare(&work.ChainShare{Share: &wire.Share{
+		Hash:      known,
+		POWHash:   &notBlock,
+		MinHeader: wire.SmallBlockHeader{Bits: 0x1d00ffff},
+		ShareInfo: wire.ShareInfo{
+			ShareData: wire.ShareData{PreviousShareHash: &chainhash.Hash{}},
+			AbsWork:   big.NewInt(7),
+		},
+	}})
+
+	wrapped := big.NewInt(0).Lsh(big.NewInt(1), 128)
+	wrapped.Sub(wrapped, big.NewInt(1))
+	unknown := &Peer{RemoteHost: "unknown"}
+	unknownShare := &Peer{RemoteHost: "unknown share", bestHash: &chainhash.Hash{2}}
+	announced := &Peer{RemoteHost: "announced", bestHash: known}
+	low := &Peer{RemoteHost: "low", bestWork: big.NewInt(5)}
+	// Absolute work wraps around, so 3 is more than 2^128-1
+	beforeWrap := &Peer{RemoteHost: "before wrap", bestWork: wrapped}
+	afterWrap := &Peer{RemoteHost: "after wrap", bestWork: big.NewInt(3)}
+
+	p := &PeerManager{
+		peers:      []*Peer{unknown, low, unknownShare, beforeWrap, announced, afterWrap},
+		shareChain: sc,
+	}
+	expected := []*Peer{announced, low, afterWrap, beforeWrap, unknown, unknownShare}
+	pws := p.peersByWork()
+	if len(pws) != len(expected) {
+		t.Fatalf("Expected %d peers, got %d", len(expected), len(pws))
+	}
+	for i, pw := range pws {
+		if pw.peer != expected[i] {
+			t.Errorf("Expected peer %d to be %s, got %s", i, expected[i].RemoteHost, pw.peer.RemoteHost)
+		}
+	}
+	if pws[0].work.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("Expected the work of the announced share, got %v", pws[0].work)
+	}
+}
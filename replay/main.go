@@ -0,0 +1,100 @@
+// Command replay plays back capture files recorded by the node or by
+// debugproxy. The messages received in each capture are fed into a
+// P2PoolConnection over an in-memory pipe and printed as JSON, one line per
+// message, as they come out decoded on the other side.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+type record struct {
+	File    string      `json:"file"`
+	Index   int         `json:"index"`
+	Command string      `json:"command"`
+	Message interface{} `json:"message"`
+}
+
+func main() {
+	logLevel := flag.String("log-level", "warn", "Log level (debug, info, warn, error)")
+	quiet := flag.Bool("quiet", false, "Only report errors, do not print the decoded messages")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] capture-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	err := logging.SetLevels(*logLevel)
+	if err != nil {
+		fail(err)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, file := range flag.Args() {
+		count, err := replay(file, *quiet)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: replay failed after %d messages: %s\n", file, count, err.Error())
+			failed = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: replayed %d messages\n", file, count)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func replay(file string, quiet bool) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cr, err := wire.NewCaptureReader(f)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, done := wire.NewReplayConnection(ctx, cr, wire.ConnConfig{})
+
+	enc := json.NewEncoder(os.Stdout)
+	count := 0
+	for msg := range conn.Incoming {
+		if !quiet {
+			err = enc.Encode(record{File: file, Index: count, Command: msg.Command(), Message: wire.JSONValue(msg)})
+			if err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+	conn.Wait()
+
+	res := <-done
+	if res.Err != nil {
+		return count, res.Err
+	}
+	if count != res.Played {
+		return count, fmt.Errorf("Connection decoded %d of %d messages", count, res.Played)
+	}
+	return count, nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
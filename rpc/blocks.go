@@ -0,0 +1,26 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// BlockConfirmations returns the number of confirmations of the block with
+// hash, which is -1 when the block is not in the main chain. It returns
+// found false when the daemon does not know the block at all.
+func (c *Client) BlockConfirmations(ctx context.Context, hash *chainhash.Hash) (int64, bool, error) {
+	var block struct {
+		Confirmations int64 `json:"confirmations"`
+	}
+	err := c.Call(ctx, "getblock", &block, hash.String())
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) && rpcErr.Code == ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return block.Confirmations, true, nil
+}
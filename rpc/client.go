@@ -0,0 +1,119 @@
+// Package rpc is a client for the JSON-RPC interface of coin daemons.
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/metrics"
+)
+
+// requestTimeout limits a single call, daemons answer mining calls quickly
+const requestTimeout = 30 * time.Second
+
+// Client calls methods on a daemon over HTTP with basic authentication
+type Client struct {
+	url      string
+	user     string
+	password string
+	http     *nethttp.Client
+	id       uint64
+}
+
+// Error is an error returned by the daemon for a call
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// Error codes daemons return
+const (
+	// ErrNotFound is returned for unknown blocks, transactions and
+	// addresses
+	ErrNotFound = -5
+	// ErrMethodNotFound is returned for unknown methods
+	ErrMethodNotFound = -32601
+)
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// NewClient creates a client for the daemon at url, such as
+// http://127.0.0.1:8332/. The user and password may be empty.
+func NewClient(url, user, password string) *Client {
+	return &Client{
+		url:      url,
+		user:     user,
+		password: password,
+		http:     &nethttp.Client{Timeout: requestTimeout},
+	}
+}
+
+// Call calls method with params and decodes the result into result, which
+// may be nil when the result does not matter.
+func (c *Client) Call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(request{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&c.id, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := nethttp.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	metrics.RPCLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	defer resp.Body.Close()
+	if resp.StatusCode == nethttp.StatusUnauthorized {
+		return fmt.Errorf("RPC call %s was refused, check the RPC user and password", method)
+	}
+
+	// Daemons answer errors with a status code other than 200 but still
+	// describe them in the body
+	var r response
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return fmt.Errorf("RPC call %s failed with status %s: %s", method, resp.Status, err.Error())
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
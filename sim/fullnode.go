@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// BlockTemplate holds what a miner needs from the fullnode to build a share
+type BlockTemplate struct {
+	Version       int32
+	PreviousBlock *chainhash.Hash
+	Height        int
+	Bits          uint32
+	Subsidy       uint64
+	Timestamp     uint32
+}
+
+// FakeFullnode stands in for the coin daemon. It hands out block templates
+// and accepts shares that meet the block target as new blocks.
+type FakeFullnode struct {
+	lock    sync.Mutex
+	bits    uint32
+	subsidy uint64
+	blocks  []*chainhash.Hash
+}
+
+func NewFakeFullnode(bits uint32, subsidy uint64) *FakeFullnode {
+	return &FakeFullnode{
+		bits:    bits,
+		subsidy: subsidy,
+		blocks:  []*chainhash.Hash{&chainhash.Hash{}},
+	}
+}
+
+func (f *FakeFullnode) BlockTemplate() BlockTemplate {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return BlockTemplate{
+		Version:       0x20000000,
+		PreviousBlock: f.blocks[len(f.blocks)-1],
+		Height:        len(f.blocks),
+		Bits:          f.bits,
+		Subsidy:       f.subsidy,
+		Timestamp:     uint32(time.Now().Unix()),
+	}
+}
+
+// SubmitBlock accepts the share as a block if it builds on the best block
+// and meets the block target.
+func (f *FakeFullnode) SubmitBlock(s *wire.Share) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !s.MinHeader.PreviousBlock.IsEqual(f.blocks[len(f.blocks)-1]) {
+		return false
+	}
+	if !s.IsBlock() {
+		return false
+	}
+	f.blocks = append(f.blocks, s.Hash)
+	return true
+}
+
+// Height returns the number of blocks found on top of the genesis block
+func (f *FakeFullnode) Height() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.blocks) - 1
+}
+
+// BlockConfirmations implements work.BlockChecker. Every block the fake
+// fullnode accepted is in its main chain.
+func (f *FakeFullnode) BlockConfirmations(ctx context.Context, hash *chainhash.Hash) (int64, bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, h := range f.blocks {
+		if h.IsEqual(hash) {
+			return int64(len(f.blocks) - i), true, nil
+		}
+	}
+	return 0, false, nil
+}
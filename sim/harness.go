@@ -0,0 +1,200 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/p2pool-go/config"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+)
+
+// blockCheckInterval is how often nodes ask the fake fullnode about the
+// blocks they found
+const blockCheckInterval = 100 * time.Millisecond
+
+// Harness runs a number of in-process nodes on the regtest network that are
+// all connected to each other and share a fake fullnode.
+type Harness struct {
+	Network  p2pnet.Network
+	Fullnode *FakeFullnode
+	Nodes    []*Node
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	dataDir string
+}
+
+// NewHarness starts nodeCount nodes, each storing its data in a subdirectory
+// of dataDir, and connects every node to every other node.
+func NewHarness(ctx context.Context, nodeCount int, dataDir string) (*Harness, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	h := &Harness{
+		Network:  p2pnet.Regtest(),
+		Fullnode: NewFakeFullnode(0x200fffff, 50*100000000),
+		Nodes:    make([]*Node, 0, nodeCount),
+		ctx:      ctx,
+		cancel:   cancel,
+		dataDir:  dataDir,
+	}
+
+	for i := 0; i < nodeCount; i++ {
+		name := fmt.Sprintf("node%d", i)
+		nd, err := NewNode(ctx, h.Network, name, filepath.Join(dataDir, name))
+		if err != nil {
+			h.Stop()
+			return nil, err
+		}
+		nd.ShareChain.Blocks.Follow(ctx, h.Fullnode, blockCheckInterval)
+		h.Nodes = append(h.Nodes, nd)
+	}
+
+	for i, nd := range h.Nodes {
+		for _, other := range h.Nodes[:i] {
+			err := nd.Connect(other)
+			if err != nil {
+				h.Stop()
+				return nil, fmt.Errorf("Could not connect %s to %s: %s", nd.Name, other.Name, err.Error())
+			}
+		}
+	}
+
+	err := h.waitFor(5*time.Second, func() error {
+		for _, nd := range h.Nodes {
+			if c := nd.PeerManager.GetPeerCount(); c != nodeCount-1 {
+				return fmt.Errorf("%s has %d peers, expected %d", nd.Name, c, nodeCount-1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+	return h, nil
+}
+
+// AddNode starts another node with the default configuration changed by
+// configure. It is not connected to the other nodes, so configure has to
+// give it peers.
+func (h *Harness) AddNode(name string, configure func(*config.Config)) (*Node, error) {
+	nd, err := NewNodeWithConfig(h.ctx, h.Network, name, filepath.Join(h.dataDir, name), configure)
+	if err != nil {
+		return nil, err
+	}
+	nd.ShareChain.Blocks.Follow(h.ctx, h.Fullnode, blockCheckInterval)
+	h.Nodes = append(h.Nodes, nd)
+	return nd, nil
+}
+
+// MineShares lets the nodes take turns mining count shares, waiting after
+// each share until all nodes have it as their tip. It returns the number of
+// blocks found.
+func (h *Harness) MineShares(count int, timeout time.Duration) (int, error) {
+	blocks := 0
+	for i := 0; i < count; i++ {
+		nd := h.Nodes[i%len(h.Nodes)]
+		s, foundBlock, err := nd.MineShare(h.Fullnode)
+		if err != nil {
+			return blocks, err
+		}
+		if foundBlock {
+			blocks++
+		}
+		err = h.WaitForTip(s.Hash, timeout)
+		if err != nil {
+			return blocks, err
+		}
+	}
+	return blocks, nil
+}
+
+// WaitForTip waits until every node has the share with the given hash as its
+// tip.
+func (h *Harness) WaitForTip(hash *chainhash.Hash, timeout time.Duration) error {
+	return h.waitFor(timeout, func() error {
+		for _, nd := range h.Nodes {
+			tip := nd.ShareChain.GetTipHash()
+			if tip == nil || !tip.IsEqual(hash) {
+				return fmt.Errorf("%s has tip %v, expected %s", nd.Name, tip, hash.String())
+			}
+		}
+		return nil
+	})
+}
+
+// CheckConverged verifies that all nodes agree on the tip, the length of the
+// chain and the payouts for the tip.
+func (h *Harness) CheckConverged() error {
+	first := h.Nodes[0]
+	tip := first.ShareChain.GetTipHash()
+	if tip == nil {
+		return fmt.Errorf("%s has no shares", first.Name)
+	}
+	payouts, err := first.ShareChain.GetPayouts(tip)
+	if err != nil {
+		return err
+	}
+	length := first.ShareChain.GetShareCount()
+
+	for _, nd := range h.Nodes[1:] {
+		ndTip := nd.ShareChain.GetTipHash()
+		if ndTip == nil || !ndTip.IsEqual(tip) {
+			return fmt.Errorf("%s has tip %v, %s has %s", nd.Name, ndTip, first.Name, tip.String())
+		}
+		if l := nd.ShareChain.GetShareCount(); l != length {
+			return fmt.Errorf("%s has %d shares, %s has %d", nd.Name, l, first.Name, length)
+		}
+		ndPayouts, err := nd.ShareChain.GetPayouts(tip)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(payouts, ndPayouts) {
+			return fmt.Errorf("%s calculates payouts %v, %s calculates %v", nd.Name, ndPayouts, first.Name, payouts)
+		}
+	}
+	return nil
+}
+
+// CheckBlocks waits until every node has recorded count found blocks and has
+// seen each of them confirmed by the fake fullnode at the height it was
+// mined at.
+func (h *Harness) CheckBlocks(count int, timeout time.Duration) error {
+	return h.waitFor(timeout, func() error {
+		for _, nd := range h.Nodes {
+			blocks := nd.ShareChain.Blocks.Blocks(count + 1)
+			if len(blocks) != count {
+				return fmt.Errorf("%s recorded %d found blocks, expected %d", nd.Name, len(blocks), count)
+			}
+			for i, fb := range blocks {
+				if height := int64(count - i); fb.Height != height || fb.Confirmations != int64(i+1) {
+					return fmt.Errorf("%s has block %s at height %d with %d confirmations, expected height %d with %d",
+						nd.Name, fb.Hash, fb.Height, fb.Confirmations, height, i+1)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Stop shuts down all nodes and waits for them to exit
+func (h *Harness) Stop() {
+	h.cancel()
+	for _, nd := range h.Nodes {
+		nd.Wait()
+	}
+}
+
+func (h *Harness) waitFor(timeout time.Duration, cond func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := cond()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
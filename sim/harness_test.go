@@ -0,0 +1,52 @@
+package sim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHarnessConverges(t *testing.T) {
+	h, err := NewHarness(context.Background(), 3, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	blocks, err := h.MineShares(20, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.CheckConverged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := h.Nodes[0].ShareChain.GetShareCount(); count != 20 {
+		t.Fatalf("Expected 20 shares in the chain, got %d", count)
+	}
+
+	// Every node calculated the same payouts; they should pay out the whole
+	// subsidy to the miners of all three nodes
+	sc := h.Nodes[0].ShareChain
+	payouts, err := sc.GetPayouts(sc.GetTipHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := payouts.Donation
+	for _, nd := range h.Nodes {
+		if payouts.Amounts[nd.Address()] == 0 {
+			t.Errorf("%s is not paid", nd.Name)
+		}
+	}
+	for _, amount := range payouts.Amounts {
+		total += amount
+	}
+	if subsidy := sc.GetTip().ShareInfo.ShareData.Subsidy; total != subsidy {
+		t.Errorf("Payouts add up to %d, subsidy is %d", total, subsidy)
+	}
+
+	err = h.CheckBlocks(blocks, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strconv"
+	"sync"
+)
+
+const igdService = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+const igdDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <friendlyName>Fake gateway</friendlyName>
+    <UDN>uuid:00000000-0000-0000-0000-000000000001</UDN>
+    <serviceList>
+      <service>
+        <serviceType>` + igdService + `</serviceType>
+        <serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+        <SCPDURL>/scpd.xml</SCPDURL>
+        <controlURL>/control</controlURL>
+        <eventSubURL>/event</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`
+
+// IGDMapping is a port mapping held by a FakeIGD
+type IGDMapping struct {
+	Protocol       string
+	ExternalPort   int
+	InternalPort   int
+	InternalClient string
+	Description    string
+	Lease          int
+}
+
+// FakeIGD stands in for a router that maps ports through UPnP. It serves a
+// device description with a single WANIPConnection service on a loopback
+// port, which can be used with the upnp:<URL> NAT method.
+type FakeIGD struct {
+	externalIP net.IP
+	listener   net.Listener
+	server     *nethttp.Server
+
+	lock     sync.Mutex
+	mappings map[string]IGDMapping
+	adds     int
+}
+
+// NewFakeIGD starts a fake router that reports externalIP as its address
+func NewFakeIGD(externalIP net.IP) (*FakeIGD, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeIGD{externalIP: externalIP, listener: l, mappings: make(map[string]IGDMapping)}
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, igdDescription)
+	})
+	mux.HandleFunc("/control", f.control)
+	f.server = &nethttp.Server{Handler: mux}
+	go f.server.Serve(l)
+	return f, nil
+}
+
+// URL returns the location of the device description
+func (f *FakeIGD) URL() string {
+	return "http://" + f.listener.Addr().String() + "/rootDesc.xml"
+}
+
+// Mappings returns the current port mappings
+func (f *FakeIGD) Mappings() []IGDMapping {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	mappings := make([]IGDMapping, 0, len(f.mappings))
+	for _, m := range f.mappings {
+		mappings = append(mappings, m)
+	}
+	return mappings
+}
+
+// AddRequests returns the number of AddPortMapping requests received, which
+// includes renewals of existing mappings
+func (f *FakeIGD) AddRequests() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.adds
+}
+
+// Close stops the fake router
+func (f *FakeIGD) Close() error {
+	return f.server.Close()
+}
+
+type soapRequest struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+func (f *FakeIGD) control(w nethttp.ResponseWriter, r *nethttp.Request) {
+	var req soapRequest
+	err := xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		nethttp.Error(w, err.Error(), nethttp.StatusBadRequest)
+		return
+	}
+	args := map[string]string{}
+	for _, a := range req.Body.Action.Args {
+		args[a.XMLName.Local] = a.Value
+	}
+	action := req.Body.Action.XMLName.Local
+
+	result := ""
+	f.lock.Lock()
+	switch action {
+	case "GetExternalIPAddress":
+		result = "<NewExternalIPAddress>" + f.externalIP.String() + "</NewExternalIPAddress>"
+	case "AddPortMapping":
+		m := IGDMapping{
+			Protocol:       args["NewProtocol"],
+			InternalClient: args["NewInternalClient"],
+			Description:    args["NewPortMappingDescription"],
+		}
+		m.ExternalPort, _ = strconv.Atoi(args["NewExternalPort"])
+		m.InternalPort, _ = strconv.Atoi(args["NewInternalPort"])
+		m.Lease, _ = strconv.Atoi(args["NewLeaseDuration"])
+		f.mappings[mappingKey(m.Protocol, args["NewExternalPort"])] = m
+		f.adds++
+	case "DeletePortMapping":
+		key := mappingKey(args["NewProtocol"], args["NewExternalPort"])
+		if _, ok := f.mappings[key]; !ok {
+			f.lock.Unlock()
+			soapFault(w, 714, "NoSuchEntryInArray")
+			return
+		}
+		delete(f.mappings, key)
+	default:
+		f.lock.Unlock()
+		soapFault(w, 401, "Invalid Action")
+		return
+	}
+	f.lock.Unlock()
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, igdService, result, action)
+}
+
+func mappingKey(protocol, port string) string {
+	return protocol + "/" + port
+}
+
+func soapFault(w nethttp.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(nethttp.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`,
+		code, desc)
+}
@@ -0,0 +1,103 @@
+package sim
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// Nothing ever checks the generation transaction of simulated shares, so any
+// prefix ending in the part before the ref hash will do.
+var simulatedGenTxPrefix = []byte("p2pool-go simulated generation transaction")
+
+const maxNonceTries = 1 << 20
+
+// coinbaseScript starts with the block height, as BIP34 requires
+func coinbaseScript(height int) string {
+	h := []byte{3, byte(height), byte(height >> 8), byte(height >> 16)}
+	return string(h) + fmt.Sprintf("p2pool-go sim %d", height)
+}
+
+// BuildShare creates a share on top of prev (nil for the first share in the
+// chain) that pays to pubKeyHash and announces staleInfo, and grinds the header nonce until the share
+// meets the maximum share target of the network.
+func BuildShare(n p2pnet.Network, prev *wire.Share, tmpl BlockTemplate, pubKeyHash []byte, staleInfo wire.StaleInfo) (wire.Share, error) {
+	shareType := n.SegwitActivationVersion
+	if shareType == 0 {
+		shareType = n.MinShareVersion
+	}
+	bits := int32(blockchain.BigToCompact(n.MaxTarget))
+
+	si := wire.ShareInfo{
+		ShareData: wire.ShareData{
+			CoinBase:          coinbaseScript(tmpl.Height),
+			Nonce:             rand.Uint32(),
+			PubKeyHash:        pubKeyHash,
+			PubKeyHashVersion: n.AddressVersion,
+			Subsidy:           tmpl.Subsidy,
+			StaleInfo:         staleInfo,
+			DesiredVersion:    shareType,
+		},
+		SegwitData: wire.SegwitData{
+			TXIDMerkleLink:  []*chainhash.Hash{},
+			WTXIDMerkleRoot: &chainhash.Hash{},
+		},
+		NewTransactionHashes: []*chainhash.Hash{},
+		TransactionHashRefs:  []wire.TransactionHashRef{},
+		FarShareHash:         &chainhash.Hash{},
+		MaxBits:              bits,
+		Bits:                 bits,
+		Timestamp:            int32(tmpl.Timestamp),
+		AbsHeight:            1,
+		AbsWork:              work.TargetToAverageAttempts(blockchain.CompactToBig(uint32(bits))),
+	}
+	if prev != nil {
+		si.ShareData.PreviousShareHash = prev.Hash
+		si.AbsHeight = prev.ShareInfo.AbsHeight + 1
+		si.AbsWork.Add(si.AbsWork, prev.ShareInfo.AbsWork)
+		// AbsWork is serialized as 128 bits and wraps around
+		si.AbsWork.Mod(si.AbsWork, big.NewInt(0).Lsh(big.NewInt(1), 128))
+	} else {
+		si.ShareData.PreviousShareHash = &chainhash.Hash{}
+	}
+
+	ending := wire.GenTxBeforeRefHash(n)
+	prefix := append(append([]byte{}, simulatedGenTxPrefix...), ending...)
+	hashLink, err := wire.PrefixToHashLink(prefix, ending)
+	if err != nil {
+		return wire.Share{}, err
+	}
+
+	s := wire.Share{
+		Type: shareType,
+		MinHeader: wire.SmallBlockHeader{
+			Version:       tmpl.Version,
+			PreviousBlock: tmpl.PreviousBlock,
+			Timestamp:     tmpl.Timestamp,
+			Bits:          tmpl.Bits,
+		},
+		ShareInfo:      si,
+		RefMerkleLink:  []*chainhash.Hash{},
+		LastTxOutNonce: rand.Uint64(),
+		HashLink:       hashLink,
+		MerkleLink:     []*chainhash.Hash{},
+	}
+
+	for i := 0; i < maxNonceTries; i++ {
+		s.MinHeader.Nonce = uint32(i)
+		err = s.CalcHashes(n)
+		if err != nil {
+			return s, err
+		}
+		if s.IsValid() {
+			return s, nil
+		}
+	}
+	return s, fmt.Errorf("No valid share found in %d tries", maxNonceTries)
+}
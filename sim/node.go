@@ -0,0 +1,119 @@
+package sim
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/gertjaap/p2pool-go/config"
+	"github.com/gertjaap/p2pool-go/nat"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/p2p"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// Node is a p2pool node running in-process, listening on a loopback port.
+type Node struct {
+	Name        string
+	Network     p2pnet.Network
+	Config      *config.Config
+	ShareChain  *work.ShareChain
+	PeerManager *p2p.PeerManager
+	PubKeyHash  []byte
+
+	ctx    context.Context
+	mapper *nat.Mapper
+}
+
+func NewNode(ctx context.Context, n p2pnet.Network, name string, dataDir string) (*Node, error) {
+	return NewNodeWithConfig(ctx, n, name, dataDir, func(*config.Config) {})
+}
+
+// NewNodeWithConfig starts a node with the default configuration changed by
+// configure.
+func NewNodeWithConfig(ctx context.Context, n p2pnet.Network, name string, dataDir string, configure func(*config.Config)) (*Node, error) {
+	cfg := config.Default()
+	cfg.Network = n.Name
+	cfg.DataDir = dataDir
+	configure(&cfg)
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	nd := &Node{Name: name, Network: n, Config: &cfg, PubKeyHash: make([]byte, 20), ctx: ctx}
+	rand.Read(nd.PubKeyHash)
+
+	nd.ShareChain = work.NewShareChain(ctx, n, cfg.NetworkDataDir())
+	err = nd.ShareChain.Load()
+	if err != nil {
+		return nil, err
+	}
+	nd.PeerManager = p2p.NewPeerManager(ctx, &cfg, n, nd.ShareChain)
+	err = nd.PeerManager.Listen(0)
+	if err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// Port returns the loopback port the node accepts peers on
+func (nd *Node) Port() int {
+	return nd.PeerManager.ListenAddr().(*net.TCPAddr).Port
+}
+
+// Address returns the payout address of the node
+func (nd *Node) Address() string {
+	return base58.CheckEncode(nd.PubKeyHash, nd.Network.AddressVersion)
+}
+
+// Connect makes an outbound connection from this node to other
+func (nd *Node) Connect(other *Node) error {
+	return nd.PeerManager.AddPeerWithPort(net.ParseIP("127.0.0.1"), other.Port())
+}
+
+// MineShare builds a share on the current tip, adds it to the local share
+// chain, announces it to the peers and submits it to the fullnode when it
+// meets the block target.
+func (nd *Node) MineShare(fn *FakeFullnode) (wire.Share, bool, error) {
+	prev := nd.ShareChain.GetTip()
+	s, err := BuildShare(nd.Network, prev, fn.BlockTemplate(), nd.PubKeyHash, nd.ShareChain.NextStaleInfo())
+	if err != nil {
+		return s, false, err
+	}
+	// The share is dead on arrival when the tip moved while it was mined
+	tip := nd.ShareChain.GetTipHash()
+	doa := prev != nil && tip != nil && !tip.IsEqual(prev.Hash)
+	nd.ShareChain.AddOwnShare(&s, doa)
+	foundBlock := fn.SubmitBlock(&s)
+
+	select {
+	case nd.ShareChain.SharesChannel <- []wire.Share{s}:
+	default:
+		return s, foundBlock, fmt.Errorf("Share chain of node %s is not keeping up", nd.Name)
+	}
+	nd.PeerManager.BroadcastShares([]wire.Share{s})
+	return s, foundBlock, nil
+}
+
+// MapPorts maps the port of the node on the router described by spec, see
+// nat.Discover, and advertises the external address to peers.
+func (nd *Node) MapPorts(spec string) {
+	mappings := []nat.Mapping{{Name: nd.Name, Protocol: "TCP", InternalPort: nd.Port()}}
+	nd.mapper = nat.NewMapper(nd.ctx, spec, mappings, func(ip net.IP, mappings []nat.Mapping) {
+		nd.PeerManager.LocalAddress().SetDiscovered(ip, mappings[0].ExternalPort)
+	})
+}
+
+// Wait blocks until the node has shut down
+func (nd *Node) Wait() {
+	nd.PeerManager.Wait()
+	nd.ShareChain.Wait()
+	nd.ShareChain.Blocks.Wait()
+	if nd.mapper != nil {
+		nd.mapper.Wait()
+	}
+}
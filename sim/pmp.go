@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// NAT-PMP clients always send their requests to this port of the gateway
+const pmpPort = 5351
+
+// PMPMapping is a port mapping held by a FakePMP
+type PMPMapping struct {
+	Protocol     string
+	InternalPort int
+	ExternalPort int
+	Lifetime     int
+}
+
+// FakePMP stands in for a router that maps ports through NAT-PMP. As NAT-PMP
+// has no way to use another port, it listens on the NAT-PMP port of a
+// loopback address, which can be used with the pmp:<gateway> NAT method.
+type FakePMP struct {
+	externalIP net.IP
+	conn       *net.UDPConn
+	started    time.Time
+	wg         sync.WaitGroup
+
+	lock     sync.Mutex
+	mappings map[string]PMPMapping
+	adds     int
+}
+
+// NewFakePMP starts a fake router on gateway, which must be a loopback
+// address, that reports externalIP as its address.
+func NewFakePMP(gateway, externalIP net.IP) (*FakePMP, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: gateway, Port: pmpPort})
+	if err != nil {
+		return nil, err
+	}
+	f := &FakePMP{
+		externalIP: externalIP.To4(),
+		conn:       conn,
+		started:    time.Now(),
+		mappings:   make(map[string]PMPMapping),
+	}
+	f.wg.Add(1)
+	go f.serve()
+	return f, nil
+}
+
+// Mappings returns the current port mappings
+func (f *FakePMP) Mappings() []PMPMapping {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	mappings := make([]PMPMapping, 0, len(f.mappings))
+	for _, m := range f.mappings {
+		mappings = append(mappings, m)
+	}
+	return mappings
+}
+
+// AddRequests returns the number of requests received that added or renewed
+// a mapping
+func (f *FakePMP) AddRequests() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.adds
+}
+
+// Close stops the fake router
+func (f *FakePMP) Close() error {
+	err := f.conn.Close()
+	f.wg.Wait()
+	return err
+}
+
+func (f *FakePMP) serve() {
+	defer f.wg.Done()
+	buf := make([]byte, 16)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		res := f.handle(buf[:n])
+		if res != nil {
+			f.conn.WriteToUDP(res, addr)
+		}
+	}
+}
+
+// handle answers a request with the response described in RFC 6886
+func (f *FakePMP) handle(req []byte) []byte {
+	if len(req) < 2 || req[0] != 0 {
+		return nil
+	}
+	op := req[1]
+	switch {
+	case op == 0:
+		res := make([]byte, 12)
+		res[1] = 128 + op
+		binary.BigEndian.PutUint32(res[4:8], f.epoch())
+		copy(res[8:12], f.externalIP)
+		return res
+	case (op == 1 || op == 2) && len(req) >= 12:
+		protocol := "udp"
+		if op == 2 {
+			protocol = "tcp"
+		}
+		m := PMPMapping{
+			Protocol:     protocol,
+			InternalPort: int(binary.BigEndian.Uint16(req[4:6])),
+			ExternalPort: int(binary.BigEndian.Uint16(req[6:8])),
+			Lifetime:     int(binary.BigEndian.Uint32(req[8:12])),
+		}
+		key := protocol + "/" + string(req[4:6])
+		f.lock.Lock()
+		if m.Lifetime == 0 {
+			// A lifetime of zero removes the mapping of the internal port
+			delete(f.mappings, key)
+			m.ExternalPort = 0
+		} else {
+			if m.ExternalPort == 0 {
+				m.ExternalPort = m.InternalPort
+			}
+			f.mappings[key] = m
+			f.adds++
+		}
+		f.lock.Unlock()
+
+		res := make([]byte, 16)
+		res[1] = 128 + op
+		binary.BigEndian.PutUint32(res[4:8], f.epoch())
+		binary.BigEndian.PutUint16(res[8:10], uint16(m.InternalPort))
+		binary.BigEndian.PutUint16(res[10:12], uint16(m.ExternalPort))
+		binary.BigEndian.PutUint32(res[12:16], uint32(m.Lifetime))
+		return res
+	}
+	return nil
+}
+
+func (f *FakePMP) epoch() uint32 {
+	return uint32(time.Since(f.started) / time.Second)
+}
@@ -0,0 +1,207 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// FakeSOCKS5 is a SOCKS5 proxy on a loopback port that stands in for Tor. It
+// connects .onion names registered with AddOnion to their loopback address,
+// and IP addresses directly. Other host names are refused, like Tor does for
+// names it cannot resolve.
+type FakeSOCKS5 struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	lock        sync.Mutex
+	onions      map[string]string
+	connections map[string]int
+	conns       map[net.Conn]bool
+}
+
+// NewFakeSOCKS5 starts a fake proxy
+func NewFakeSOCKS5() (*FakeSOCKS5, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeSOCKS5{
+		listener:    l,
+		onions:      make(map[string]string),
+		connections: make(map[string]int),
+		conns:       make(map[net.Conn]bool),
+	}
+	f.wg.Add(1)
+	go f.acceptLoop()
+	return f, nil
+}
+
+// Addr returns the host:port of the proxy
+func (f *FakeSOCKS5) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// AddOnion makes connections to name go to addr
+func (f *FakeSOCKS5) AddOnion(name string, addr string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.onions[name] = addr
+}
+
+// Connections returns how many connections were made through the proxy to
+// host, as it was named by the client.
+func (f *FakeSOCKS5) Connections(host string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.connections[host]
+}
+
+// Close stops the proxy and closes all connections going through it
+func (f *FakeSOCKS5) Close() error {
+	err := f.listener.Close()
+	f.lock.Lock()
+	for c := range f.conns {
+		c.Close()
+	}
+	f.lock.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+func (f *FakeSOCKS5) acceptLoop() {
+	defer f.wg.Done()
+	for {
+		c, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.wg.Add(1)
+		go f.serve(c)
+	}
+}
+
+func (f *FakeSOCKS5) track(c net.Conn, add bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if add {
+		f.conns[c] = true
+	} else {
+		delete(f.conns, c)
+	}
+}
+
+func (f *FakeSOCKS5) serve(c net.Conn) {
+	defer f.wg.Done()
+	f.track(c, true)
+	defer f.track(c, false)
+	defer c.Close()
+
+	host, port, err := readSOCKS5Request(c)
+	if err != nil {
+		return
+	}
+
+	f.lock.Lock()
+	target, ok := f.onions[host]
+	f.lock.Unlock()
+	if !ok {
+		if net.ParseIP(host) == nil {
+			// Host unreachable
+			c.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		target = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		// Connection refused
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	f.track(remote, true)
+	defer f.track(remote, false)
+	defer remote.Close()
+
+	f.lock.Lock()
+	f.connections[host]++
+	f.lock.Unlock()
+	_, err = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, c)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(c, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// readSOCKS5Request reads the greeting and the CONNECT request of a client
+// that does not authenticate, and returns the host and port it asks for.
+func readSOCKS5Request(c net.Conn) (string, int, error) {
+	head := make([]byte, 2)
+	_, err := io.ReadFull(c, head)
+	if err != nil {
+		return "", 0, err
+	}
+	if head[0] != 5 {
+		return "", 0, fmt.Errorf("Unsupported SOCKS version %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	_, err = io.ReadFull(c, methods)
+	if err != nil {
+		return "", 0, err
+	}
+	_, err = c.Write([]byte{5, 0})
+	if err != nil {
+		return "", 0, err
+	}
+
+	req := make([]byte, 4)
+	_, err = io.ReadFull(c, req)
+	if err != nil {
+		return "", 0, err
+	}
+	if req[1] != 1 {
+		return "", 0, fmt.Errorf("Unsupported SOCKS command %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if req[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		_, err = io.ReadFull(c, ip)
+		host = ip.String()
+	case 3:
+		l := make([]byte, 1)
+		_, err = io.ReadFull(c, l)
+		if err == nil {
+			name := make([]byte, l[0])
+			_, err = io.ReadFull(c, name)
+			host = string(name)
+		}
+	default:
+		return "", 0, fmt.Errorf("Unsupported SOCKS address type %d", req[3])
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	port := make([]byte, 2)
+	_, err = io.ReadFull(c, port)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/gertjaap/p2pool-go/config"
+	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/sim"
+)
+
+func main() {
+	nodes := flag.Int("nodes", 3, "Number of nodes to run")
+	shares := flag.Int("shares", 30, "Number of shares to mine")
+	timeout := flag.Duration("timeout", 10*time.Second, "How long to wait for each share to reach all nodes")
+	dataDir := flag.String("datadir", "", "Directory to store node data in (default a temporary directory)")
+	upnp := flag.Bool("upnp", false, "Put the first node behind a fake UPnP router and check that its port is mapped and unmapped")
+	proxy := flag.Bool("proxy", false, "After mining, add a node that reaches the first node as a .onion peer through a fake Tor proxy, and check that it syncs")
+	logLevel := flag.String("log-level", "warn", "Log level (debug, info, warn, error), optionally followed by levels per subsystem, e.g. warn,wire=debug")
+	flag.Parse()
+
+	err := logging.SetLevels(*logLevel)
+	if err != nil {
+		fail(err)
+	}
+
+	if *dataDir == "" {
+		*dataDir, err = ioutil.TempDir("", "p2pool-sim")
+		if err != nil {
+			fail(err)
+		}
+		defer os.RemoveAll(*dataDir)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	h, err := sim.NewHarness(ctx, *nodes, *dataDir)
+	if err != nil {
+		fail(err)
+	}
+
+	var igd *sim.FakeIGD
+	if *upnp {
+		igd, err = startUPnP(h)
+		if err != nil {
+			h.Stop()
+			fail(err)
+		}
+		defer igd.Close()
+	}
+
+	blocks, err := h.MineShares(*shares, *timeout)
+	if err == nil && *proxy {
+		var socks *sim.FakeSOCKS5
+		socks, err = addHiddenNode(h, *timeout)
+		if socks != nil {
+			defer socks.Close()
+		}
+	}
+	if err == nil {
+		err = h.CheckConverged()
+	}
+	if err == nil {
+		err = h.CheckBlocks(blocks, *timeout)
+	}
+	if err != nil {
+		h.Stop()
+		fail(err)
+	}
+
+	first := h.Nodes[0].ShareChain
+	tip := first.GetTipHash()
+	payouts, err := first.GetPayouts(tip)
+	h.Stop()
+	if err != nil {
+		fail(err)
+	}
+	if igd != nil && len(igd.Mappings()) != 0 {
+		fail(fmt.Errorf("Port mappings left on the router after shutdown: %v", igd.Mappings()))
+	}
+
+	fmt.Printf("All %d nodes converged on tip %s with %d shares, %d blocks found\n", len(h.Nodes), tip.String(), first.GetShareCount(), blocks)
+	addrs := make([]string, 0, len(payouts.Amounts))
+	for addr := range payouts.Amounts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		fmt.Printf("  %s: %d\n", addr, payouts.Amounts[addr])
+	}
+	fmt.Printf("  donation: %d\n", payouts.Donation)
+}
+
+// startUPnP maps the port of the first node on a fake router and waits
+// until the node advertises the external address of the router.
+func startUPnP(h *sim.Harness) (*sim.FakeIGD, error) {
+	externalIP := net.ParseIP("203.0.113.1")
+	igd, err := sim.NewFakeIGD(externalIP)
+	if err != nil {
+		return nil, err
+	}
+	nd := h.Nodes[0]
+	nd.MapPorts("upnp:" + igd.URL())
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ip, port := nd.PeerManager.LocalAddress().Get()
+		if ip.Equal(externalIP) && port == nd.Port() && len(igd.Mappings()) == 1 {
+			fmt.Printf("%s advertises %s:%d through the fake router\n", nd.Name, ip.String(), port)
+			return igd, nil
+		}
+		if time.Now().After(deadline) {
+			igd.Close()
+			return nil, fmt.Errorf("%s advertises %s:%d, router has mappings %v", nd.Name, ip.String(), port, igd.Mappings())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// addHiddenNode adds a node that only connects through a fake Tor proxy and
+// knows the first node by a .onion name, and waits until it has synced.
+func addHiddenNode(h *sim.Harness, timeout time.Duration) (*sim.FakeSOCKS5, error) {
+	socks, err := sim.NewFakeSOCKS5()
+	if err != nil {
+		return nil, err
+	}
+	first := h.Nodes[0]
+	onion := first.Name + "p2poolsimulation.onion"
+	socks.AddOnion(onion, fmt.Sprintf("127.0.0.1:%d", first.Port()))
+
+	nd, err := h.AddNode("hidden", func(c *config.Config) {
+		c.Proxy = socks.Addr()
+		c.ProxyOnly = true
+		c.Peers = fmt.Sprintf("%s:%d", onion, first.Port())
+	})
+	if err != nil {
+		return socks, err
+	}
+
+	tip := first.ShareChain.GetTipHash()
+	deadline := time.Now().Add(timeout)
+	for {
+		ndTip := nd.ShareChain.GetTipHash()
+		if ndTip != nil && ndTip.IsEqual(tip) && socks.Connections(onion) > 0 {
+			fmt.Printf("%s synced from %s through the fake proxy\n", nd.Name, onion)
+			return socks, nil
+		}
+		if time.Now().After(deadline) {
+			return socks, fmt.Errorf("%s has tip %v after %d connections to %s, expected %s", nd.Name, ndTip, socks.Connections(onion), onion, tip.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Simulation failed: %s\n", err.Error())
+	os.Exit(1)
+}
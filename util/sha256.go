@@ -122,6 +122,16 @@ func (d *Sha256Digest) CalcMidState(data, state, buf []byte, len uint64) [Size]b
 	return d.checkSum()
 }
 
+// State returns the intermediate hash state over all complete blocks written
+// so far, in the same encoding CalcMidState expects.
+func (d *Sha256Digest) State() []byte {
+	b := make([]byte, 0, 32)
+	for _, h := range d.h {
+		b = appendUint32(b, h)
+	}
+	return b
+}
+
 func (d *Sha256Digest) Reset() {
 	d.h[0] = init0
 	d.h[1] = init1
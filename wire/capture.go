@@ -0,0 +1,235 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+)
+
+// A capture file records the messages exchanged on a single connection. It
+// starts with captureMagic and the name of the network, followed by one record
+// per message: the time in nanoseconds since the Unix epoch (int64), the
+// direction (one byte) and the message framed exactly as on the wire.
+var captureMagic = []byte("P2PCAP\x00\x01")
+
+// CaptureExtension is the file extension used for capture files
+const CaptureExtension = ".p2pcap"
+
+// CaptureDirection tells whether a captured message was received or sent by
+// the side that recorded it
+type CaptureDirection uint8
+
+const (
+	CaptureReceived CaptureDirection = 0
+	CaptureSent     CaptureDirection = 1
+)
+
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureReceived:
+		return "received"
+	case CaptureSent:
+		return "sent"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(d))
+}
+
+// CaptureRecord is a single message in a capture
+type CaptureRecord struct {
+	Time      time.Time
+	Direction CaptureDirection
+	Message   *RawMessage
+}
+
+// CaptureWriter writes a capture file. It is safe for concurrent use.
+type CaptureWriter struct {
+	network p2pnet.Network
+	lock    sync.Mutex
+	w       io.Writer
+	closed  bool
+}
+
+// NewCaptureWriter writes the capture header for network n to w
+func NewCaptureWriter(w io.Writer, n p2pnet.Network) (*CaptureWriter, error) {
+	var buf bytes.Buffer
+	buf.Write(captureMagic)
+	err := WriteVarString(&buf, n.Name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{network: n, w: w}, nil
+}
+
+// CreateCapture creates a new capture file in dir. The name is made of the
+// current time and name, which is typically the remote address.
+func CreateCapture(dir string, name string, n p2pnet.Network) (*CaptureWriter, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	name = strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(name)
+	file := filepath.Join(dir, fmt.Sprintf("%s-%s%s", time.Now().UTC().Format("20060102T150405.000"), name, CaptureExtension))
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	cw, err := NewCaptureWriter(f, n)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Write appends a record to the capture
+func (cw *CaptureWriter) Write(r CaptureRecord) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, r.Time.UnixNano())
+	buf.WriteByte(byte(r.Direction))
+	err := WriteRawMessage(&buf, cw.network, r.Message)
+	if err != nil {
+		return err
+	}
+
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	if cw.closed {
+		return fmt.Errorf("Capture is closed")
+	}
+	_, err = cw.w.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer. Records written
+// after Close are rejected.
+func (cw *CaptureWriter) Close() error {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	if c, ok := cw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// CaptureReader reads the records of a capture file
+type CaptureReader struct {
+	network p2pnet.Network
+	r       io.Reader
+}
+
+// NewCaptureReader reads the capture header from r and looks up the network
+// the capture was recorded on.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	magic := make([]byte, len(captureMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, captureMagic) {
+		return nil, fmt.Errorf("Not a p2pool capture file")
+	}
+	name, err := ReadVarString(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p2pnet.ByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureReader{network: n, r: r}, nil
+}
+
+// Network returns the network the capture was recorded on
+func (cr *CaptureReader) Network() p2pnet.Network {
+	return cr.network
+}
+
+// Next returns the next record, or io.EOF after the last one
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	var ts int64
+	err := binary.Read(cr.r, binary.LittleEndian, &ts)
+	if err != nil {
+		return nil, err
+	}
+	dir := make([]byte, 1)
+	_, err = io.ReadFull(cr.r, dir)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	msg, err := ReadRawMessage(cr.r, cr.network)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureRecord{Time: time.Unix(0, ts), Direction: CaptureDirection(dir[0]), Message: msg}, nil
+}
+
+// ReplayResult tells how a play back started by NewReplayConnection ended
+type ReplayResult struct {
+	// Played is the number of messages written to the connection
+	Played int
+	// Err is the error that stopped the play back, nil when the whole
+	// capture was played
+	Err error
+}
+
+// NewReplayConnection returns a connection whose remote end plays back the
+// messages received in the capture read by cr, in order and without delay.
+// Everything sent on the connection is discarded. Once all messages have been
+// played back the remote end closes the connection and the result is sent on
+// the returned channel. A connection that decoded everything it was sent
+// delivers exactly Played messages on Incoming.
+func NewReplayConnection(ctx context.Context, cr *CaptureReader, cfg ConnConfig) (*P2PoolConnection, <-chan ReplayResult) {
+	local, remote := net.Pipe()
+	conn := NewP2PoolConnection(ctx, local, cr.network, cfg)
+	done := make(chan ReplayResult, 1)
+
+	go io.Copy(io.Discard, remote)
+	go func() {
+		defer remote.Close()
+		res := ReplayResult{}
+		defer func() {
+			done <- res
+		}()
+		for {
+			r, err := cr.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				res.Err = err
+				return
+			}
+			if r.Direction != CaptureReceived {
+				continue
+			}
+			err = WriteRawMessage(remote, cr.network, r.Message)
+			if err != nil {
+				res.Err = err
+				return
+			}
+			res.Played++
+		}
+	}()
+	return conn, done
+}
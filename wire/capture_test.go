@@ -0,0 +1,98 @@
+package wire_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// replayFile plays back the capture in file and returns the messages the
+// connection decoded
+func replayFile(t *testing.T, file string) []wire.P2PoolMessage {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cr, err := wire.NewCaptureReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, done := wire.NewReplayConnection(ctx, cr, wire.ConnConfig{})
+	msgs := make([]wire.P2PoolMessage, 0)
+	for msg := range conn.Incoming {
+		msgs = append(msgs, msg)
+	}
+	conn.Wait()
+
+	res := <-done
+	if res.Err != nil {
+		t.Fatalf("Replay failed after %d messages: %s", res.Played, res.Err.Error())
+	}
+	if len(msgs) != res.Played {
+		t.Fatalf("Connection decoded %d of %d messages", len(msgs), res.Played)
+	}
+	return msgs
+}
+
+// TestReplayCaptures replays every capture in testdata. A capture of an
+// interop bug that is added there fails this test until the bug is fixed.
+func TestReplayCaptures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*"+wire.CaptureExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No captures in testdata")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			replayFile(t, file)
+		})
+	}
+}
+
+// TestReplaySession checks the decoded contents of a session between two
+// simulated nodes, recorded by the node that connected: the version of its
+// peer followed by the two shares that peer mined.
+func TestReplaySession(t *testing.T) {
+	msgs := replayFile(t, filepath.Join("testdata", "regtest-session"+wire.CaptureExtension))
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(msgs))
+	}
+
+	version, ok := msgs[0].(*wire.MsgVersion)
+	if !ok {
+		t.Fatalf("Expected a version message first, got %s", msgs[0].Command())
+	}
+	if version.Version != 1800 || version.SubVersion != "p2pool-go/0.0.1" {
+		t.Errorf("Unexpected version %d %s", version.Version, version.SubVersion)
+	}
+
+	expected := []string{
+		"7266053fcbdef1972b29270a7a1c408f7053b4834c65c1484a455782ca718396",
+		"40222ca8f7e73e12c1d8dd07fc6fad4ee882545626ef8dec2b18c6227955b1de",
+	}
+	for i, hash := range expected {
+		msg, ok := msgs[i+1].(*wire.MsgShares)
+		if !ok {
+			t.Fatalf("Expected message %d to be shares, got %s", i+1, msgs[i+1].Command())
+		}
+		if len(msg.Shares) != 1 {
+			t.Fatalf("Expected 1 share in message %d, got %d", i+1, len(msg.Shares))
+		}
+		s := msg.Shares[0]
+		if s.Hash.String() != hash {
+			t.Errorf("Expected share %s, got %s", hash, s.Hash)
+		}
+		if !s.IsValid() {
+			t.Errorf("Share %s does not meet its target", s.Hash)
+		}
+	}
+}
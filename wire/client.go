@@ -1,21 +1,61 @@
 package wire
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"golang.org/x/net/proxy"
 )
 
-func NewP2PoolClient(ip net.IP, port int, network p2pnet.Network) (*P2PoolConnection, error) {
+const (
+	// dialTimeout limits setting up a direct connection to a peer
+	dialTimeout = 5 * time.Second
+	// proxyDialTimeout limits setting up a connection through the proxy,
+	// which takes longer when Tor has to build a circuit first
+	proxyDialTimeout = 30 * time.Second
+)
+
+// NewP2PoolClient connects to the peer at host and port. The host is an IP
+// address or a host name, which is resolved by the proxy when the connection
+// goes through one. Connections to .onion hosts, and to all hosts when
+// cfg.ProxyOnly is set, are made through the SOCKS5 proxy in cfg.Proxy.
+func NewP2PoolClient(ctx context.Context, host string, port int, network p2pnet.Network, cfg ConnConfig) (*P2PoolConnection, error) {
 	if port == 0 {
 		port = network.P2PPort
 	}
-	d := net.Dialer{Timeout: time.Second * 5}
-	conn, err := d.Dial("tcp", fmt.Sprintf("%s:%d", ip.String(), port))
+	conn, err := dial(ctx, host, port, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewP2PoolConnection(ctx, conn, network, cfg), nil
+}
+
+func dial(ctx context.Context, host string, port int, cfg ConnConfig) (net.Conn, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if !cfg.ProxyOnly && !IsOnion(host) {
+		d := net.Dialer{Timeout: dialTimeout}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	if cfg.Proxy == "" {
+		return nil, fmt.Errorf("Cannot connect to %s without a proxy", addr)
+	}
+
+	d, err := proxy.SOCKS5("tcp", cfg.Proxy, nil, &net.Dialer{Timeout: dialTimeout})
 	if err != nil {
 		return nil, err
 	}
-	return NewP2PoolConnection(conn, network), nil
+	ctx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+	defer cancel()
+	return d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// IsOnion returns true when host is a Tor onion service address, which can
+// only be reached through a proxy.
+func IsOnion(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".onion")
 }
@@ -0,0 +1,89 @@
+package wire_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// dialAndPing connects to host:port with cfg, sends a ping and checks it
+// arrives on l
+func dialAndPing(t *testing.T, l net.Listener, host string, port int, cfg wire.ConnConfig) {
+	t.Helper()
+	n := p2pnet.Regtest()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := wire.NewP2PoolClient(ctx, host, port, n, cfg)
+	if err != nil {
+		t.Fatalf("Could not connect to %s: %s", host, err.Error())
+	}
+	defer conn.Wait()
+	defer conn.Close()
+
+	remote, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	conn.Send(&wire.MsgPing{})
+	raw, err := wire.ReadRawMessage(remote, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Command != "ping" {
+		t.Fatalf("Expected a ping, got %s", raw.Command)
+	}
+}
+
+func TestDialThroughProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	proxy, err := sim.NewFakeSOCKS5()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	onion := "abcdefghijklmnop.onion"
+	proxy.AddOnion(onion, l.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = wire.NewP2PoolClient(ctx, onion, port, p2pnet.Regtest(), wire.ConnConfig{})
+	if err == nil {
+		t.Fatal("Expected connecting to an onion address without a proxy to fail")
+	}
+
+	// Onion hosts go through the proxy, which is handed the name
+	cfg := wire.ConnConfig{Proxy: proxy.Addr()}
+	dialAndPing(t, l, onion, port, cfg)
+	if c := proxy.Connections(onion); c != 1 {
+		t.Fatalf("Expected 1 connection to %s through the proxy, got %d", onion, c)
+	}
+
+	// Other hosts are dialed directly, unless every peer has to go through
+	// the proxy
+	dialAndPing(t, l, "127.0.0.1", port, cfg)
+	if c := proxy.Connections("127.0.0.1"); c != 0 {
+		t.Fatalf("Expected a direct connection, got %d through the proxy", c)
+	}
+	cfg.ProxyOnly = true
+	dialAndPing(t, l, "127.0.0.1", port, cfg)
+	if c := proxy.Connections("127.0.0.1"); c != 1 {
+		t.Fatalf("Expected 1 connection through the proxy, got %d", c)
+	}
+
+	// Names the proxy cannot resolve are refused
+	_, err = wire.NewP2PoolClient(ctx, "unknown.onion", port, p2pnet.Regtest(), cfg)
+	if err == nil {
+		t.Fatal("Expected connecting to an unknown onion address to fail")
+	}
+}
@@ -1,14 +1,15 @@
 package wire
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/binary"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/metrics"
 	p2pnet "github.com/gertjaap/p2pool-go/net"
 )
 
@@ -18,121 +19,169 @@ type P2PoolMessage interface {
 	ToBytes() ([]byte, error)
 }
 
+// Defaults for the settings in ConnConfig that are left zero
+const (
+	DefaultPingInterval  = 15 * time.Second
+	DefaultIdlePings     = 8
+	DefaultWriteTimeout  = 30 * time.Second
+	DefaultMaxQueueBytes = 16 * 1024 * 1024
+)
+
+// ConnConfig holds the settings shared by all connections to peers
+type ConnConfig struct {
+	// CaptureDir is the directory each connection records its traffic to.
+	// Nothing is recorded when it is empty.
+	CaptureDir string
+	// PingInterval is how often a ping is sent to keep the connection alive
+	PingInterval time.Duration
+	// ReadTimeout is how long to wait for the next message, from the end of
+	// the previous one, before the peer is considered gone. It defaults to
+	// DefaultIdlePings times PingInterval.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long writing a single message may take before the
+	// peer is considered stalled and disconnected.
+	WriteTimeout time.Duration
+	// MaxQueueBytes limits the payload bytes waiting to be sent to a peer.
+	// A peer that does not read fast enough to keep its queue below the
+	// limit is disconnected.
+	MaxQueueBytes int
+	// Proxy is the host:port of a SOCKS5 proxy, such as Tor, that .onion
+	// peers are dialed through.
+	Proxy string
+	// ProxyOnly makes all peers, not only .onion ones, dialed through
+	// Proxy.
+	ProxyOnly bool
+}
+
+func (cfg ConnConfig) withDefaults() ConnConfig {
+	if cfg.PingInterval == 0 {
+		cfg.PingInterval = DefaultPingInterval
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = DefaultIdlePings * cfg.PingInterval
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+	if cfg.MaxQueueBytes == 0 {
+		cfg.MaxQueueBytes = DefaultMaxQueueBytes
+	}
+	return cfg
+}
+
 type P2PoolConnection struct {
 	conn         net.Conn
 	network      p2pnet.Network
-	connLock     sync.Mutex
+	capture      *CaptureWriter
+	queue        *sendQueue
+	cfg          ConnConfig
+	connected    time.Time
+	lastReceived int64 // unix nanoseconds, accessed atomically
 	Incoming     chan P2PoolMessage
-	Outgoing     chan P2PoolMessage
 	Disconnected chan bool
+
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
-func NewP2PoolConnection(c net.Conn, n p2pnet.Network) *P2PoolConnection {
+// NewP2PoolConnection wraps an established connection and starts its read and
+// write loops. The connection is closed when ctx is cancelled.
+func NewP2PoolConnection(ctx context.Context, c net.Conn, n p2pnet.Network, cfg ConnConfig) *P2PoolConnection {
+	cfg = cfg.withDefaults()
 	in := make(chan P2PoolMessage, 10)
-	out := make(chan P2PoolMessage, 10)
 	dis := make(chan bool, 1) // Need a buffer here. Client could be processing a message when disconnect happens
 	p2pc := &P2PoolConnection{
 		conn:         c,
 		network:      n,
-		connLock:     sync.Mutex{},
+		queue:        newSendQueue(cfg.MaxQueueBytes),
+		cfg:          cfg,
+		connected:    time.Now(),
 		Incoming:     in,
-		Outgoing:     out,
 		Disconnected: dis,
+		quit:         make(chan struct{}),
 	}
 
-	go p2pc.IncomingLoop()
-	go p2pc.OutgoingLoop()
-	return p2pc
-}
-
-func (c *P2PoolConnection) ReadBytes(len int) ([]byte, error) {
-	buf := make([]byte, len)
-	if len == 0 {
-		return buf, nil
-	}
-	read := 0
-	for {
-		r, err := c.conn.Read(buf[read:])
+	if cfg.CaptureDir != "" {
+		cw, err := CreateCapture(cfg.CaptureDir, c.RemoteAddr().String(), n)
 		if err != nil {
-			return nil, err
-		}
-		if r == 0 {
-			return nil, fmt.Errorf("Could not read enough bytes")
-		}
-		read += r
-		if read == len {
-			break
+			log.Warn("Could not create capture file", "peer", c.RemoteAddr(), "err", err)
+		} else {
+			p2pc.capture = cw
 		}
 	}
 
-	return buf, nil
+	p2pc.wg.Add(2)
+	go p2pc.IncomingLoop()
+	go p2pc.OutgoingLoop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			p2pc.Close()
+		case <-p2pc.quit:
+		}
+	}()
+	return p2pc
 }
 
 func (c *P2PoolConnection) IncomingLoop() {
 	defer func() {
+		c.Close()
+		close(c.Incoming)
 		select {
 		case c.Disconnected <- true:
 		default:
 		}
+		c.wg.Done()
 	}()
 
 	for {
-		prefix, err := c.ReadBytes(len(c.network.MessagePrefix))
-		if err != nil {
-			logging.Errorf("Error reading from connection: %s", err.Error())
-			break
-		}
-
-		if !bytes.Equal(prefix, c.network.MessagePrefix) {
-			logging.Errorf("Received transport message with mismatching prefix")
-			break
-		}
-
-		commandBytes, err := c.ReadBytes(12)
+		err := c.conn.SetReadDeadline(time.Now().Add(c.cfg.ReadTimeout))
 		if err != nil {
-			logging.Errorf("Error reading from connection: %s", err.Error())
+			log.Error("Could not set read deadline", "peer", c.RemoteAddr(), "err", err)
 			break
 		}
-		command := string(bytes.Trim(commandBytes, "\x00"))
-
-		var length int32
-		err = binary.Read(c.conn, binary.LittleEndian, &length)
+		raw, err := ReadRawMessage(c.conn, c.network)
 		if err != nil {
-			logging.Errorf("Error reading from connection: %s", err.Error())
+			ne, isNetErr := err.(net.Error)
+			if c.closed() {
+				log.Debug("Connection closed", "peer", c.RemoteAddr())
+			} else if isNetErr && ne.Timeout() {
+				log.Warn("No message received in time, disconnecting", "peer", c.RemoteAddr(), "timeout", c.cfg.ReadTimeout)
+			} else if err == io.EOF {
+				log.Debug("Peer disconnected", "peer", c.RemoteAddr())
+			} else {
+				log.Error("Error reading from connection", "peer", c.RemoteAddr(), "err", err)
+			}
 			break
 		}
 
-		checksum, err := c.ReadBytes(4)
-		if err != nil {
-			logging.Errorf("Error reading from connection: %s", err.Error())
-			break
-		}
+		atomic.StoreInt64(&c.lastReceived, time.Now().UnixNano())
+		log.Debug("Received message", "peer", c.RemoteAddr(), "command", raw.Command, "length", len(raw.Payload))
+		c.record(CaptureReceived, raw)
 
-		payload, err := c.ReadBytes(int(length))
+		msg, err := ParseMessage(c.network, raw.Command, raw.Payload)
 		if err != nil {
-			logging.Errorf("Error reading from connection: %s", err.Error())
+			if raw.Command == "shares" || raw.Command == "sharereply" {
+				metrics.InvalidShares.WithLabelValues(metrics.InvalidReasonDecode).Inc()
+			}
+			log.Error("Could not parse message", "peer", c.RemoteAddr(), "command", raw.Command, "err", err)
 			break
 		}
-		calcChecksum := sha256.Sum256(payload)
-		calcChecksum = sha256.Sum256(calcChecksum[:])
-		if !bytes.Equal(checksum, calcChecksum[:4]) {
-			logging.Errorf("Wrong checksum - expected [%x] got [%x]", calcChecksum, checksum)
-			break
-		}
-
-		logging.Debugf("Received message of type [%s] length [%d]", command, length)
+		metrics.MessagesReceived.WithLabelValues(raw.Command).Inc()
+		metrics.BytesReceived.WithLabelValues(raw.Command).Add(float64(raw.Len(c.network)))
 
-		// TODO: Actually parse it :)
-		msg, err := c.ParseMessage(command, payload)
-		if err != nil {
-			logging.Errorf("Could not parse message: %s", err.Error())
-			break
+		select {
+		case c.Incoming <- msg:
+		case <-c.quit:
+			return
 		}
-		c.Incoming <- msg
 	}
 }
 
-func (c *P2PoolConnection) ParseMessage(command string, payload []byte) (P2PoolMessage, error) {
+// ParseMessage decodes the payload of a message with the given command for
+// network n.
+func ParseMessage(n p2pnet.Network, command string, payload []byte) (P2PoolMessage, error) {
 	var msg P2PoolMessage
 	switch command {
 	case "version":
@@ -156,9 +205,9 @@ func (c *P2PoolConnection) ParseMessage(command string, payload []byte) (P2PoolM
 	case "losing_tx":
 		msg = &MsgLosingTx{}
 	case "shares":
-		msg = &MsgShares{}
+		msg = &MsgShares{Network: n}
 	case "sharereply":
-		msg = &MsgShareReply{}
+		msg = &MsgShareReply{Network: n}
 	case "sharereq":
 		msg = &MsgShareReq{}
 	default:
@@ -168,28 +217,153 @@ func (c *P2PoolConnection) ParseMessage(command string, payload []byte) (P2PoolM
 	return msg, err
 }
 
+// OutgoingLoop writes the queued messages, highest priority first, until the
+// connection is closed.
 func (c *P2PoolConnection) OutgoingLoop() {
-	for msg := range c.Outgoing {
-		payload, err := msg.ToBytes()
+	defer func() {
+		c.queue.close()
+		c.wg.Done()
+	}()
+	for {
+		raw := c.queue.pop()
+		if raw == nil {
+			select {
+			case <-c.queue.ready:
+				continue
+			case <-c.quit:
+				return
+			}
+		}
+
+		err := c.writeMessage(raw)
 		if err != nil {
-			continue
+			if !c.closed() {
+				log.Error("Error writing to connection", "peer", c.RemoteAddr(), "command", raw.Command, "err", err)
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					metrics.StalledPeers.Inc()
+				}
+			}
+			c.Close()
+			return
 		}
+	}
+}
 
-		calcChecksum := sha256.Sum256(payload)
-		calcChecksum = sha256.Sum256(calcChecksum[:])
-		command := make([]byte, 12)
-		copy(command, []byte(msg.Command()))
+func (c *P2PoolConnection) writeMessage(raw *RawMessage) error {
+	log.Debug("Sending message", "peer", c.RemoteAddr(), "command", raw.Command, "length", len(raw.Payload))
 
-		logging.Debugf("Sending p2pool message [%s] length [%d]", msg.Command(), len(payload))
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	if err != nil {
+		return err
+	}
+	err = WriteRawMessage(c.conn, c.network, raw)
+	if err != nil {
+		return err
+	}
+	c.record(CaptureSent, raw)
+	metrics.MessagesSent.WithLabelValues(raw.Command).Inc()
+	metrics.BytesSent.WithLabelValues(raw.Command).Add(float64(raw.Len(c.network)))
+	return nil
+}
+
+func (c *P2PoolConnection) record(dir CaptureDirection, raw *RawMessage) {
+	if c.capture == nil {
+		return
+	}
+	err := c.capture.Write(CaptureRecord{Time: time.Now(), Direction: dir, Message: raw})
+	if err != nil && !c.closed() {
+		log.Warn("Could not write to capture file", "peer", c.RemoteAddr(), "err", err)
+	}
+}
 
-		c.conn.Write(c.network.MessagePrefix)
-		c.conn.Write(command)
-		binary.Write(c.conn, binary.LittleEndian, int32(len(payload)))
-		c.conn.Write(calcChecksum[:4])
-		c.conn.Write(payload)
+// Send queues a message for writing and never blocks. Messages are sent in
+// the order of their MessagePriority. When the send queue is full, queued
+// messages of a lower priority are dropped to make room; if that is not
+// enough the peer is not keeping up and is disconnected. Send returns false
+// when the message was not queued.
+func (c *P2PoolConnection) Send(msg P2PoolMessage) bool {
+	if c.closed() {
+		return false
+	}
+	payload, err := msg.ToBytes()
+	if err != nil {
+		log.Warn("Could not serialize message", "command", msg.Command(), "err", err)
+		return false
+	}
+
+	raw := &RawMessage{Command: msg.Command(), Payload: payload}
+	if c.queue.push(raw, MessagePriority(raw.Command)) {
+		return true
+	}
+	if !c.closed() {
+		count, size := c.queue.len()
+		log.Error("Send queue full, disconnecting stalled peer", "peer", c.RemoteAddr(), "command", raw.Command, "queued", count, "bytes", size)
+		metrics.StalledPeers.Inc()
+		c.Close()
 	}
+	return false
 }
 
+// QueueLen returns the number of messages waiting to be sent and their total
+// payload size in bytes.
+func (c *P2PoolConnection) QueueLen() (int, int) {
+	return c.queue.len()
+}
+
+// Config returns the settings of the connection, with defaults filled in
+func (c *P2PoolConnection) Config() ConnConfig {
+	return c.cfg
+}
+
+// Connected returns the time the connection was established
+func (c *P2PoolConnection) Connected() time.Time {
+	return c.connected
+}
+
+// LastReceived returns the time the last message was received, or the zero
+// time when nothing has been received yet.
+func (c *P2PoolConnection) LastReceived() time.Time {
+	t := atomic.LoadInt64(&c.lastReceived)
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+// RemoteAddr returns the address of the other end of the connection.
+func (c *P2PoolConnection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Done returns a channel that is closed once the connection is closed.
+func (c *P2PoolConnection) Done() <-chan struct{} {
+	return c.quit
+}
+
+func (c *P2PoolConnection) closed() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the underlying connection and stops the read and write loops.
+// It is safe to call more than once.
 func (c *P2PoolConnection) Close() error {
-	return c.conn.Close()
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.quit)
+		err = c.conn.Close()
+		if c.capture != nil {
+			c.capture.Close()
+		}
+	})
+	return err
+}
+
+// Wait blocks until the read and write loops have exited.
+func (c *P2PoolConnection) Wait() {
+	c.wg.Wait()
 }
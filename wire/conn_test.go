@@ -0,0 +1,97 @@
+package wire_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// pipeConnection returns a connection over one end of a net.Pipe and the
+// other end, which the test reads and writes as the peer.
+func pipeConnection(t *testing.T, cfg wire.ConnConfig) (*wire.P2PoolConnection, net.Conn) {
+	local, remote := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := wire.NewP2PoolConnection(ctx, local, p2pnet.Regtest(), cfg)
+	t.Cleanup(func() {
+		cancel()
+		remote.Close()
+		conn.Wait()
+	})
+	return conn, remote
+}
+
+func expectClosed(t *testing.T, conn *wire.P2PoolConnection, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-conn.Done():
+	case <-time.After(timeout):
+		t.Fatal("Connection was not closed")
+	}
+}
+
+func TestSendQueueFullDisconnects(t *testing.T) {
+	// Nobody reads the other end, so the first message blocks the write
+	// loop and the rest stays queued
+	conn, _ := pipeConnection(t, wire.ConnConfig{MaxQueueBytes: 64})
+	msg := &wire.MsgHaveTx{TXHashes: []*chainhash.Hash{{1}, {2}, {3}}}
+
+	sent := 0
+	for conn.Send(msg) {
+		sent++
+		if sent > 3 {
+			t.Fatal("Send kept queueing messages beyond the limit")
+		}
+	}
+	expectClosed(t, conn, time.Second)
+	if conn.Send(msg) {
+		t.Fatal("Expected Send on a closed connection to fail")
+	}
+}
+
+func TestWriteStallDisconnects(t *testing.T) {
+	conn, _ := pipeConnection(t, wire.ConnConfig{WriteTimeout: 50 * time.Millisecond})
+	if !conn.Send(&wire.MsgPing{}) {
+		t.Fatal("Could not queue a ping")
+	}
+	expectClosed(t, conn, time.Second)
+}
+
+func TestIdleDisconnects(t *testing.T) {
+	conn, _ := pipeConnection(t, wire.ConnConfig{ReadTimeout: 50 * time.Millisecond})
+	expectClosed(t, conn, time.Second)
+	select {
+	case <-conn.Disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("Disconnect was not reported")
+	}
+}
+
+func TestReadDeadlineExtended(t *testing.T) {
+	timeout := 100 * time.Millisecond
+	conn, remote := pipeConnection(t, wire.ConnConfig{ReadTimeout: timeout})
+
+	// A peer that keeps sending stays connected well past the timeout
+	for i := 0; i < 8; i++ {
+		err := wire.WriteRawMessage(remote, p2pnet.Regtest(), &wire.RawMessage{Command: "ping"})
+		if err != nil {
+			t.Fatalf("Could not send ping %d: %s", i, err.Error())
+		}
+		select {
+		case <-conn.Incoming:
+		case <-conn.Done():
+			t.Fatalf("Connection closed after %d pings", i)
+		}
+		time.Sleep(timeout / 2)
+	}
+	if conn.LastReceived().IsZero() {
+		t.Fatal("Expected the time of the last message to be recorded")
+	}
+
+	// and is dropped once it goes quiet
+	expectClosed(t, conn, 10*timeout)
+}
@@ -0,0 +1,86 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+)
+
+// MaxPayloadLength is the largest message payload that is accepted, the same
+// limit the reference implementation uses
+const MaxPayloadLength = 8000000
+
+// RawMessage is a single message as framed on the wire: the network's message
+// prefix, a 12 byte command, the payload length, a checksum and the payload.
+type RawMessage struct {
+	Command string
+	Payload []byte
+}
+
+// Len returns the number of bytes the message takes on the wire for network n
+func (m *RawMessage) Len(n p2pnet.Network) int {
+	return len(n.MessagePrefix) + 20 + len(m.Payload)
+}
+
+// ReadRawMessage reads a single framed message from r, verifying the prefix
+// and checksum.
+func ReadRawMessage(r io.Reader, n p2pnet.Network) (*RawMessage, error) {
+	header := make([]byte, len(n.MessagePrefix)+20)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := header[:len(n.MessagePrefix)]
+	if !bytes.Equal(prefix, n.MessagePrefix) {
+		return nil, fmt.Errorf("Received transport message with mismatching prefix %x", prefix)
+	}
+	header = header[len(n.MessagePrefix):]
+
+	command := string(bytes.TrimRight(header[:12], "\x00"))
+	length := binary.LittleEndian.Uint32(header[12:16])
+	checksum := header[16:20]
+	if length > MaxPayloadLength {
+		return nil, fmt.Errorf("Payload of [%s] message too large: %d bytes", command, length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	calcChecksum := messageChecksum(payload)
+	if !bytes.Equal(checksum, calcChecksum) {
+		return nil, fmt.Errorf("Wrong checksum on [%s] message - expected [%x] got [%x]", command, calcChecksum, checksum)
+	}
+
+	return &RawMessage{Command: command, Payload: payload}, nil
+}
+
+// WriteRawMessage frames the message for network n and writes it to w with a
+// single Write call.
+func WriteRawMessage(w io.Writer, n p2pnet.Network, m *RawMessage) error {
+	command := make([]byte, 12)
+	copy(command, []byte(m.Command))
+
+	var buf bytes.Buffer
+	buf.Write(n.MessagePrefix)
+	buf.Write(command)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(m.Payload)))
+	buf.Write(messageChecksum(m.Payload))
+	buf.Write(m.Payload)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func messageChecksum(payload []byte) []byte {
+	h := sha256.Sum256(payload)
+	h = sha256.Sum256(h[:])
+	return h[:4]
+}
@@ -0,0 +1,98 @@
+package wire
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"unicode"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+)
+
+// JSONValue converts a message, share or any value made of them into a value
+// that encoding/json renders readably: hashes and IP addresses as their usual
+// string form, byte slices and binary strings as hex and big integers as
+// decimal strings. It is
+// meant for inspection tools, not for exchanging data.
+func JSONValue(v interface{}) interface{} {
+	return jsonValue(reflect.ValueOf(v))
+}
+
+var (
+	bigIntType  = reflect.TypeOf(big.Int{})
+	networkType = reflect.TypeOf(p2pnet.Network{})
+)
+
+func jsonValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem() == bigIntType {
+			return v.Interface().(*big.Int).String()
+		}
+		return jsonValue(v.Elem())
+	}
+
+	// Hashes, IP addresses and enums like StaleInfo know how to print
+	// themselves. Structs are expanded instead so no fields get lost.
+	if v.Kind() != reflect.Struct && v.CanInterface() {
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" || f.Type == networkType {
+				continue // unexported, or the network the value is encoded for
+			}
+			m[f.Name] = jsonValue(v.Field(i))
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hex.EncodeToString(b)
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return []interface{}{}
+		}
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = jsonValue(v.Index(i))
+		}
+		return l
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(jsonValue(iter.Key()))] = jsonValue(iter.Value())
+		}
+		return m
+	case reflect.String:
+		// Some strings, like HashLink.State, hold binary data
+		str := v.String()
+		for _, r := range str {
+			if !unicode.IsPrint(r) {
+				return hex.EncodeToString([]byte(str))
+			}
+		}
+		return str
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil
+	}
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	return fmt.Sprint(v)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
 )
 
 var _ P2PoolMessage = &MsgShareReply{}
@@ -21,9 +22,10 @@ const (
 )
 
 type MsgShareReply struct {
-	ID     *chainhash.Hash
-	Result MsgShareReplyResult
-	Shares []Share
+	Network p2pnet.Network
+	ID      *chainhash.Hash
+	Result  MsgShareReplyResult
+	Shares  []Share
 }
 
 func (m *MsgShareReply) FromBytes(b []byte) error {
@@ -40,7 +42,7 @@ func (m *MsgShareReply) FromBytes(b []byte) error {
 
 	m.Result = MsgShareReplyResult(result)
 
-	m.Shares, err = ReadShares(r)
+	m.Shares, err = ReadShares(r, m.Network)
 	if err != nil {
 		return err
 	}
@@ -61,7 +63,10 @@ func (m *MsgShareReply) ToBytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	//err = WriteShares(&buf, m.Shares)
+	err = WriteShares(&buf, m.Network, m.Shares)
+	if err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
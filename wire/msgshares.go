@@ -3,10 +3,10 @@ package wire
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"math/big"
 	"time"
 
@@ -14,7 +14,6 @@ import (
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	btcwire "github.com/btcsuite/btcd/wire"
-	"github.com/gertjaap/p2pool-go/logging"
 	p2pnet "github.com/gertjaap/p2pool-go/net"
 	"github.com/gertjaap/p2pool-go/util"
 )
@@ -22,7 +21,8 @@ import (
 var _ P2PoolMessage = &MsgShares{}
 
 type MsgShares struct {
-	Shares []Share
+	Network p2pnet.Network
+	Shares  []Share
 }
 
 type Share struct {
@@ -96,8 +96,17 @@ const (
 	StaleInfoDOA    = StaleInfo(254)
 )
 
-var DonationScript []byte
-var GenTxBeforeRefHash []byte
+func (si StaleInfo) String() string {
+	switch si {
+	case StaleInfoNone:
+		return "none"
+	case StaleInfoOrphan:
+		return "orphan"
+	case StaleInfoDOA:
+		return "doa"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(si))
+}
 
 type SegwitData struct {
 	TXIDMerkleLink  []*chainhash.Hash
@@ -139,9 +148,31 @@ func CalcMerkleLink(tip *chainhash.Hash, link []*chainhash.Hash, linkIndex int)
 	return h, nil
 }
 
+// PrefixToHashLink computes the hash link for data that ends with ending, so
+// that CalcHashLink can later hash data followed by arbitrary bytes without
+// knowing the start of data.
+func PrefixToHashLink(data []byte, ending []byte) (HashLink, error) {
+	if !bytes.HasSuffix(data, ending) {
+		return HashLink{}, fmt.Errorf("Data does not end with the constant ending")
+	}
+	extralength := len(data) % 64
+	if extralength > len(ending) {
+		return HashLink{}, fmt.Errorf("Hash link would need %d bytes of extra data", extralength-len(ending))
+	}
+
+	s := util.NewSha256()
+	s.Write(data)
+	return HashLink{State: string(s.State()), Length: uint64(len(data))}, nil
+}
+
 func CalcHashLink(hl HashLink, data []byte, ending []byte) (*chainhash.Hash, error) {
 
 	extralength := hl.Length % 64
+	if extralength > uint64(len(ending)) {
+		// The hash link was made for another constant ending, as happens
+		// when decoding a share with the parameters of another network
+		return nil, fmt.Errorf("Hash link needs %d bytes of extra data, the ending has %d", extralength, len(ending))
+	}
 	extra := ending[len(ending)-int(extralength):]
 
 	s := util.NewSha256()
@@ -151,89 +182,140 @@ func CalcHashLink(hl HashLink, data []byte, ending []byte) (*chainhash.Hash, err
 	return chainhash.NewHash(s.Sum(nil))
 }
 
-func ReadShares(r io.Reader) ([]Share, error) {
+// GenTxBeforeRefHash returns the part of the generation transaction that
+// precedes the ref hash: the donation output followed by the start of the
+// OP_RETURN output that commits to the share.
+func GenTxBeforeRefHash(n p2pnet.Network) []byte {
+	b := make([]byte, len(n.DonationScript)+12)
+	copy(b, []byte{byte(len(n.DonationScript))})
+	copy(b[1:], n.DonationScript)
+	copy(b[len(n.DonationScript)+9:], []byte{42, 0x6A, 0x28})
+	return b
+}
+
+// ErrShareVersion is returned by ReadShare for shares with a version below
+// the minimum of the network. Their contents have been skipped, so reading
+// can continue with the next share.
+var ErrShareVersion = errors.New("Share version below the minimum of the network")
+
+// ReadShares reads a list of shares. Shares below the minimum version of the
+// network are left out, the others are still returned.
+func ReadShares(r io.Reader, n p2pnet.Network) ([]Share, error) {
 	shares := make([]Share, 0)
 	count, err := ReadVarInt(r)
 	if err != nil {
 		return shares, err
 	}
-	logging.Debugf("Deserializing %d shares", count)
+	log.Debugf("Deserializing %d shares", count)
 	for i := uint64(0); i < count; i++ {
-		s := Share{}
-		s.Type, err = ReadVarInt(r)
-		if err != nil {
-			return shares, err
+		s, err := ReadShare(r, n)
+		if errors.Is(err, ErrShareVersion) {
+			log.Debugf("Skipping share: %s", err.Error())
+			continue
 		}
-
-		// REad length - not needed for us
-		_, err := ReadVarInt(r)
-		if err != nil {
-			return shares, err
-		}
-
-		s.MinHeader, err = ReadSmallBlockHeader(r)
 		if err != nil {
 			return shares, err
 		}
+		shares = append(shares, s)
+	}
+	return shares, nil
+}
 
-		s.ShareInfo, err = ReadShareInfo(r, s.Type >= 17)
+// ReadShare reads a single share, its type followed by its length prefixed
+// contents, and calculates its hashes.
+func ReadShare(r io.Reader, n p2pnet.Network) (Share, error) {
+	s := Share{}
+	var err error
+	s.Type, err = ReadVarInt(r)
+	if err != nil {
+		return s, err
+	}
+	length, err := ReadVarInt(r)
+	if err != nil {
+		return s, err
+	}
+	if s.Type < n.MinShareVersion {
+		_, err = io.CopyN(ioutil.Discard, r, int64(length))
 		if err != nil {
-			return shares, err
+			return s, err
 		}
+		return s, fmt.Errorf("%w: version %d, minimum %d", ErrShareVersion, s.Type, n.MinShareVersion)
+	}
+	segwit := n.IsSegwitShare(s.Type)
 
-		s.RefMerkleLink, err = ReadChainHashList(r)
-		if err != nil {
-			return shares, err
-		}
+	s.MinHeader, err = ReadSmallBlockHeader(r)
+	if err != nil {
+		return s, err
+	}
 
-		err = binary.Read(r, binary.LittleEndian, &s.LastTxOutNonce)
-		if err != nil {
-			return shares, err
-		}
+	s.ShareInfo, err = ReadShareInfo(r, segwit)
+	if err != nil {
+		return s, err
+	}
 
-		s.HashLink, err = ReadHashLink(r)
-		if err != nil {
-			return shares, err
-		}
+	s.RefMerkleLink, err = ReadChainHashList(r)
+	if err != nil {
+		return s, err
+	}
 
-		s.MerkleLink, err = ReadChainHashList(r)
-		if err != nil {
-			return shares, err
-		}
+	err = binary.Read(r, binary.LittleEndian, &s.LastTxOutNonce)
+	if err != nil {
+		return s, err
+	}
 
-		s.RefHash, _ = GetRefHash(p2pnet.ActiveNetwork, s.ShareInfo, s.RefMerkleLink, s.Type >= 17)
+	s.HashLink, err = ReadHashLink(r)
+	if err != nil {
+		return s, err
+	}
 
-		var buf bytes.Buffer
-		buf.Write(s.RefHash.CloneBytes())
-		binary.Write(&buf, binary.LittleEndian, s.LastTxOutNonce)
-		binary.Write(&buf, binary.LittleEndian, int32(0))
-		s.GenTXHash, err = CalcHashLink(s.HashLink, buf.Bytes(), GenTxBeforeRefHash)
-		if err != nil {
-			return shares, err
-		}
+	s.MerkleLink, err = ReadChainHashList(r)
+	if err != nil {
+		return s, err
+	}
 
-		merkleLink := s.MerkleLink
-		if s.Type >= 17 {
-			merkleLink = s.ShareInfo.SegwitData.TXIDMerkleLink
-		}
-		s.MerkleRoot, err = CalcMerkleLink(s.GenTXHash, merkleLink, 0)
-		if err != nil {
-			return shares, err
-		}
+	err = s.CalcHashes(n)
+	return s, err
+}
 
-		buf.Reset()
+// CalcHashes derives RefHash, GenTXHash, MerkleRoot, Hash and POWHash from the
+// serialized fields of the share.
+func (s *Share) CalcHashes(n p2pnet.Network) error {
+	var err error
+	segwit := n.IsSegwitShare(s.Type)
 
-		hdr := btcwire.NewBlockHeader(s.MinHeader.Version, s.MinHeader.PreviousBlock, s.MerkleRoot, s.MinHeader.Bits, s.MinHeader.Nonce)
-		hdr.Timestamp = time.Unix(int64(s.MinHeader.Timestamp), 0)
-		hdr.Serialize(&buf)
-		headerBytes := buf.Bytes()
+	s.RefHash, err = GetRefHash(n, s.ShareInfo, s.RefMerkleLink, segwit)
+	if err != nil {
+		return err
+	}
 
-		s.POWHash, _ = chainhash.NewHash(p2pnet.ActiveNetwork.POWHash(headerBytes[:]))
-		s.Hash, _ = chainhash.NewHash(util.Sha256d(headerBytes[:]))
+	var buf bytes.Buffer
+	buf.Write(s.RefHash.CloneBytes())
+	binary.Write(&buf, binary.LittleEndian, s.LastTxOutNonce)
+	binary.Write(&buf, binary.LittleEndian, int32(0))
+	s.GenTXHash, err = CalcHashLink(s.HashLink, buf.Bytes(), GenTxBeforeRefHash(n))
+	if err != nil {
+		return err
+	}
 
-		shares = append(shares, s)
+	merkleLink := s.MerkleLink
+	if segwit {
+		merkleLink = s.ShareInfo.SegwitData.TXIDMerkleLink
 	}
-	return shares, nil
+	s.MerkleRoot, err = CalcMerkleLink(s.GenTXHash, merkleLink, 0)
+	if err != nil {
+		return err
+	}
+
+	buf.Reset()
+
+	hdr := btcwire.NewBlockHeader(s.MinHeader.Version, s.MinHeader.PreviousBlock, s.MerkleRoot, s.MinHeader.Bits, s.MinHeader.Nonce)
+	hdr.Timestamp = time.Unix(int64(s.MinHeader.Timestamp), 0)
+	hdr.Serialize(&buf)
+	headerBytes := buf.Bytes()
+
+	s.POWHash, _ = chainhash.NewHash(n.POWHash(headerBytes[:]))
+	s.Hash, _ = chainhash.NewHash(util.Sha256d(headerBytes[:]))
+	return nil
 }
 
 func (s Share) IsValid() bool {
@@ -245,7 +327,14 @@ func (s Share) IsValid() bool {
 	return true
 }
 
-func WriteShares(w io.Writer, shares []Share) error {
+// IsBlock returns true when the share's proof of work also meets the target
+// of the block it was mined on.
+func (s Share) IsBlock() bool {
+	target := blockchain.CompactToBig(s.MinHeader.Bits)
+	return blockchain.HashToBig(s.POWHash).Cmp(target) <= 0
+}
+
+func WriteShares(w io.Writer, n p2pnet.Network, shares []Share) error {
 	err := WriteVarInt(w, uint64(len(shares)))
 	if err != nil {
 		return err
@@ -263,7 +352,7 @@ func WriteShares(w io.Writer, shares []Share) error {
 			return err
 		}
 
-		err = WriteShareInfo(&buf, s.ShareInfo, s.Type >= 17)
+		err = WriteShareInfo(&buf, s.ShareInfo, n.IsSegwitShare(s.Type))
 		if err != nil {
 			return err
 		}
@@ -308,43 +397,24 @@ func (m *MsgShares) FromBytes(b []byte) error {
 	var err error
 
 	r := bytes.NewReader(b)
-	m.Shares, err = ReadShares(r)
+	m.Shares, err = ReadShares(r, m.Network)
 	if err != nil {
 		return err
 	}
-	log.Printf("Deserialized %d shares", len(m.Shares))
+	log.Debug("Deserialized shares", "count", len(m.Shares))
 	return nil
 }
 
 func (m *MsgShares) ToBytes() ([]byte, error) {
 	var buf bytes.Buffer
 
-	err := WriteVarInt(&buf, uint64(len(m.Shares)))
+	err := WriteShares(&buf, m.Network, m.Shares)
 	if err != nil {
 		return nil, err
 	}
-	for _, s := range m.Shares {
-		err = WriteVarInt(&buf, s.Type)
-		if err != nil {
-			return nil, err
-		}
-		err = WriteSmallBlockHeader(&buf, s.MinHeader)
-		if err != nil {
-			return nil, err
-		}
-	}
 	return buf.Bytes(), nil
 }
 
 func (m *MsgShares) Command() string {
 	return "shares"
 }
-
-func init() {
-	DonationScript, _ = hex.DecodeString("410418a74130b2f4fad899d8ed2bff272bc43a03c8ca72897ae3da584d7a770b5a9ea8dd1b37a620d27c6cf6d5a7a9bbd6872f5981e95816d701d94f201c5d093be6ac")
-	GenTxBeforeRefHash = make([]byte, len(DonationScript)+12)
-	copy(GenTxBeforeRefHash, []byte{byte(len(DonationScript))})
-	copy(GenTxBeforeRefHash[1:], DonationScript)
-	copy(GenTxBeforeRefHash[len(DonationScript)+9:], []byte{42, 0x6A, 0x28})
-
-}
@@ -0,0 +1,116 @@
+package wire_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// TestReadSharesNetworks decodes the same bytes with networks that differ in
+// their share parameters.
+func TestReadSharesNetworks(t *testing.T) {
+	regtest := p2pnet.Regtest()
+	tmpl := sim.NewFakeFullnode(0x200fffff, 50*100000000).BlockTemplate()
+	pubKeyHash := make([]byte, 20)
+
+	// Without segwit activation the simulated miner builds shares of the
+	// minimum version
+	legacy := regtest
+	legacy.SegwitActivationVersion = 0
+	old, err := sim.BuildShare(legacy, nil, tmpl, pubKeyHash, wire.StaleInfoNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := sim.BuildShare(regtest, &old, tmpl, pubKeyHash, wire.StaleInfoNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Type == current.Type {
+		t.Fatalf("Expected shares of different versions, both are %d", old.Type)
+	}
+
+	var buf bytes.Buffer
+	err = wire.WriteShares(&buf, regtest, []wire.Share{old, current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	shares, err := wire.ReadShares(bytes.NewReader(b), regtest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 2 || !shares[0].Hash.IsEqual(old.Hash) || !shares[1].Hash.IsEqual(current.Hash) {
+		t.Fatalf("Expected shares %s and %s, got %v", old.Hash, current.Hash, shares)
+	}
+
+	// A network that no longer accepts the old version skips that share and
+	// still decodes the rest
+	strict := regtest
+	strict.MinShareVersion = current.Type
+	r := bytes.NewReader(b)
+	shares, err = wire.ReadShares(r, strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 1 || !shares[0].Hash.IsEqual(current.Hash) {
+		t.Fatalf("Expected only share %s, got %v", current.Hash, shares)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes left after decoding", r.Len())
+	}
+
+	// Whether a share carries segwit data depends on the network, so the
+	// segwit share is not read back on a network without segwit shares
+	nosegwit := regtest
+	nosegwit.SegwitActivationVersion = 0
+	shares, err = wire.ReadShares(bytes.NewReader(b), nosegwit)
+	if err == nil && (len(shares) != 2 || shares[1].Hash.IsEqual(current.Hash)) {
+		t.Fatalf("Expected the segwit share to decode differently without segwit, got %v", shares)
+	}
+	if len(shares) < 1 || !shares[0].Hash.IsEqual(old.Hash) {
+		t.Fatalf("Expected share %s to decode the same on both networks, got %v", old.Hash, shares)
+	}
+
+	// With a donation script of another length the hash links do not fit,
+	// which is an error rather than a crash
+	p2pkh := regtest
+	p2pkh.DonationScript, _ = hex.DecodeString("76a914000000000000000000000000000000000000000088ac")
+	_, err = wire.ReadShares(bytes.NewReader(b), p2pkh)
+	if err == nil {
+		t.Fatal("Expected an error decoding with a shorter donation script")
+	}
+}
+
+func TestReadShareBelowMinimumVersion(t *testing.T) {
+	regtest := p2pnet.Regtest()
+	tmpl := sim.NewFakeFullnode(0x200fffff, 50*100000000).BlockTemplate()
+	s, err := sim.BuildShare(regtest, nil, tmpl, make([]byte, 20), wire.StaleInfoNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = wire.WriteShares(&buf, regtest, []wire.Share{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strict := regtest
+	strict.MinShareVersion = s.Type + 1
+	r := bytes.NewReader(buf.Bytes())
+	_, err = wire.ReadVarInt(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wire.ReadShare(r, strict)
+	if err == nil {
+		t.Fatal("Expected an error for a share below the minimum version")
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes of the share left unread", r.Len())
+	}
+}
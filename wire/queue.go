@@ -0,0 +1,155 @@
+package wire
+
+import (
+	"sync"
+
+	"github.com/gertjaap/p2pool-go/metrics"
+)
+
+// Priority decides the order in which queued messages are sent. Messages of a
+// higher priority are always sent first, messages of the same priority in the
+// order they were queued.
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+
+	numPriorities = 3
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	}
+	return "unknown"
+}
+
+// MessagePriority returns the priority a message is queued with. Shares and
+// everything needed to get them across go first, transaction relay next and
+// address gossip and pings last.
+func MessagePriority(command string) Priority {
+	switch command {
+	case "version", "shares", "sharereq", "sharereply", "bestblock":
+		return PriorityHigh
+	case "have_tx", "losing_tx", "remember_tx", "forget_tx":
+		return PriorityNormal
+	}
+	return PriorityLow
+}
+
+// sendQueue holds the serialized messages waiting to be written to a peer.
+// Its size is bounded by the total payload size of the queued messages.
+type sendQueue struct {
+	lock     sync.Mutex
+	queues   [numPriorities][]*RawMessage
+	bytes    int
+	maxBytes int
+	closed   bool
+	ready    chan struct{}
+}
+
+func newSendQueue(maxBytes int) *sendQueue {
+	return &sendQueue{maxBytes: maxBytes, ready: make(chan struct{}, 1)}
+}
+
+// push queues m. When the queue is too full, queued messages of a lower
+// priority than m are dropped, oldest first, to make room. A message is
+// always accepted into an empty queue, whatever its size. It returns false
+// when m does not fit or the queue is closed.
+func (q *sendQueue) push(m *RawMessage, prio Priority) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false
+	}
+
+	size := len(m.Payload)
+	for q.bytes > 0 && q.bytes+size > q.maxBytes {
+		if !q.dropLowest(prio) {
+			return false
+		}
+	}
+
+	q.queues[prio] = append(q.queues[prio], m)
+	q.bytes += size
+	metrics.SendQueueMessages.Inc()
+	metrics.SendQueueBytes.Add(float64(size))
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dropLowest drops the oldest message with a priority below prio. The caller
+// must hold the lock.
+func (q *sendQueue) dropLowest(prio Priority) bool {
+	for p := Priority(numPriorities - 1); p > prio; p-- {
+		if len(q.queues[p]) == 0 {
+			continue
+		}
+		m := q.queues[p][0]
+		q.queues[p][0] = nil
+		q.queues[p] = q.queues[p][1:]
+		q.remove(m)
+		metrics.SendQueueDropped.WithLabelValues(m.Command).Inc()
+		return true
+	}
+	return false
+}
+
+// pop returns the next message to send, or nil when the queue is empty
+func (q *sendQueue) pop() *RawMessage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for p := range q.queues {
+		if len(q.queues[p]) > 0 {
+			m := q.queues[p][0]
+			q.queues[p][0] = nil
+			q.queues[p] = q.queues[p][1:]
+			q.remove(m)
+			return m
+		}
+	}
+	return nil
+}
+
+// remove updates the accounting for a message taken off the queue. The
+// caller must hold the lock.
+func (q *sendQueue) remove(m *RawMessage) {
+	q.bytes -= len(m.Payload)
+	metrics.SendQueueMessages.Dec()
+	metrics.SendQueueBytes.Sub(float64(len(m.Payload)))
+}
+
+// close drops all queued messages and refuses new ones
+func (q *sendQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	for p := range q.queues {
+		for _, m := range q.queues[p] {
+			q.remove(m)
+		}
+		q.queues[p] = nil
+	}
+}
+
+// len returns the number of queued messages and their total payload size
+func (q *sendQueue) len() (int, int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	count := 0
+	for p := range q.queues {
+		count += len(q.queues[p])
+	}
+	return count, q.bytes
+}
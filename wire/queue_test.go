@@ -0,0 +1,102 @@
+package wire
+
+import "testing"
+
+func testMessage(command string, size int) *RawMessage {
+	return &RawMessage{Command: command, Payload: make([]byte, size)}
+}
+
+// popCommands empties q and returns the commands in the order they came out
+func popCommands(q *sendQueue) []string {
+	commands := make([]string, 0)
+	for m := q.pop(); m != nil; m = q.pop() {
+		commands = append(commands, m.Command)
+	}
+	return commands
+}
+
+func expectCommands(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	q := newSendQueue(1000)
+	q.push(testMessage("low1", 10), PriorityLow)
+	q.push(testMessage("normal1", 10), PriorityNormal)
+	q.push(testMessage("high1", 10), PriorityHigh)
+	q.push(testMessage("low2", 10), PriorityLow)
+	q.push(testMessage("high2", 10), PriorityHigh)
+	q.push(testMessage("normal2", 10), PriorityNormal)
+
+	expectCommands(t, popCommands(q), "high1", "high2", "normal1", "normal2", "low1", "low2")
+	if count, size := q.len(); count != 0 || size != 0 {
+		t.Fatalf("Expected an empty queue, got %d messages of %d bytes", count, size)
+	}
+}
+
+func TestSendQueueDropsLowerPriority(t *testing.T) {
+	q := newSendQueue(100)
+	for _, m := range []struct {
+		command string
+		prio    Priority
+	}{
+		{"low1", PriorityLow},
+		{"normal1", PriorityNormal},
+		{"low2", PriorityLow},
+		{"high1", PriorityHigh},
+	} {
+		if !q.push(testMessage(m.command, 30), m.prio) {
+			t.Fatalf("Could not queue %s", m.command)
+		}
+	}
+	// 120 bytes do not fit: the oldest low priority message makes room
+	if count, size := q.len(); count != 3 || size != 90 {
+		t.Fatalf("Expected 3 messages of 90 bytes, got %d of %d", count, size)
+	}
+
+	// Making room for 60 bytes drops the remaining low priority message
+	// first and then the normal priority one
+	if !q.push(testMessage("high2", 60), PriorityHigh) {
+		t.Fatal("Could not queue high2")
+	}
+	expectCommands(t, popCommands(q), "high1", "high2")
+}
+
+func TestSendQueueFull(t *testing.T) {
+	q := newSendQueue(100)
+	if !q.push(testMessage("normal1", 60), PriorityNormal) {
+		t.Fatal("Could not queue normal1")
+	}
+	// Messages of the same or a lower priority are never dropped for a new
+	// one
+	if q.push(testMessage("normal2", 60), PriorityNormal) {
+		t.Fatal("Expected normal2 to be refused")
+	}
+	if q.push(testMessage("low1", 60), PriorityLow) {
+		t.Fatal("Expected low1 to be refused")
+	}
+	if count, size := q.len(); count != 1 || size != 60 {
+		t.Fatalf("Expected 1 message of 60 bytes, got %d of %d", count, size)
+	}
+	expectCommands(t, popCommands(q), "normal1")
+
+	// An empty queue accepts a message larger than the limit
+	if !q.push(testMessage("high1", 200), PriorityHigh) {
+		t.Fatal("Expected an empty queue to accept high1")
+	}
+	q.close()
+	if q.push(testMessage("high2", 10), PriorityHigh) {
+		t.Fatal("Expected a closed queue to refuse high2")
+	}
+	if count, size := q.len(); count != 0 || size != 0 {
+		t.Fatalf("Expected close to empty the queue, got %d messages of %d bytes", count, size)
+	}
+}
@@ -1,6 +1,7 @@
 package wire
 
 import (
+	"context"
 	"fmt"
 	"net"
 
@@ -8,18 +9,29 @@ import (
 )
 
 type P2PoolListener struct {
+	ctx     context.Context
 	listen  net.Listener
 	network p2pnet.Network
+	cfg     ConnConfig
 }
 
-func NewP2PoolListener(port int, network p2pnet.Network) (*P2PoolListener, error) {
-	listen, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// NewP2PoolListener listens for incoming p2pool connections. The listener and
+// all connections it accepts are closed when ctx is cancelled.
+func NewP2PoolListener(ctx context.Context, port int, network p2pnet.Network, cfg ConnConfig) (*P2PoolListener, error) {
+	var lc net.ListenConfig
+	listen, err := lc.Listen(ctx, "tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
+	go func() {
+		<-ctx.Done()
+		listen.Close()
+	}()
 	return &P2PoolListener{
+		ctx:     ctx,
 		listen:  listen,
 		network: network,
+		cfg:     cfg,
 	}, nil
 }
 
@@ -29,5 +41,13 @@ func (p2pl *P2PoolListener) Accept() (*P2PoolConnection, error) {
 		return nil, err
 	}
 
-	return NewP2PoolConnection(conn, p2pl.network), nil
+	return NewP2PoolConnection(p2pl.ctx, conn, p2pl.network, p2pl.cfg), nil
+}
+
+func (p2pl *P2PoolListener) Close() error {
+	return p2pl.listen.Close()
+}
+
+func (p2pl *P2PoolListener) Addr() net.Addr {
+	return p2pl.listen.Addr()
 }
@@ -0,0 +1,309 @@
+package work
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/gertjaap/p2pool-go/metrics"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// FoundBlocksFile is the name of the file found blocks are recorded in,
+// inside the network data directory
+const FoundBlocksFile = "blocks.json"
+
+// BlockCheckInterval is how often the fullnode is asked about pending blocks
+const BlockCheckInterval = time.Minute
+
+// unknownBlockTimeout is how long the fullnode may not know a found block
+// before it is considered orphaned. A block the fullnode never accepted
+// did not make it into the chain.
+const unknownBlockTimeout = time.Hour
+
+// maxFoundBlocks is the number of found blocks kept, older ones are dropped
+const maxFoundBlocks = 1000
+
+// BlockStatus is what became of a found block
+type BlockStatus string
+
+const (
+	// BlockPending blocks have fewer confirmations than the coinbase
+	// maturity of the network
+	BlockPending = BlockStatus("pending")
+	// BlockConfirmed blocks have reached coinbase maturity
+	BlockConfirmed = BlockStatus("confirmed")
+	// BlockOrphaned blocks are not in the main chain of the fullnode
+	BlockOrphaned = BlockStatus("orphaned")
+)
+
+// FoundBlock is a share that met the block target. The JSON names of the
+// fields shared with the reference implementation's /recent_blocks match
+// it.
+type FoundBlock struct {
+	Hash          string      `json:"hash"`
+	Height        int64       `json:"number"`
+	Share         string      `json:"share"`
+	Timestamp     int64       `json:"ts"`
+	Finder        string      `json:"finder"`
+	Reward        uint64      `json:"reward"`
+	Status        BlockStatus `json:"status"`
+	Confirmations int64       `json:"confirmations"`
+}
+
+// BlockChecker looks up blocks in the parent chain, normally through the
+// fullnode. Confirmations is -1 for blocks that are not in the main chain
+// and found is false for blocks the chain does not know.
+type BlockChecker interface {
+	BlockConfirmations(ctx context.Context, hash *chainhash.Hash) (confirmations int64, found bool, err error)
+}
+
+// BlockTracker keeps a persistent record of the last maxFoundBlocks blocks
+// found by the p2pool network, as seen in the share chain, and follows
+// whether they made it into the parent chain.
+type BlockTracker struct {
+	network p2pnet.Network
+	path    string
+	wg      sync.WaitGroup
+
+	lock   sync.Mutex
+	blocks []*FoundBlock // by Timestamp, oldest first
+	byHash map[string]*FoundBlock
+	dirty  bool
+}
+
+// NewBlockTracker creates a tracker that records blocks in dataDir
+func NewBlockTracker(n p2pnet.Network, dataDir string) *BlockTracker {
+	return &BlockTracker{
+		network: n,
+		path:    filepath.Join(dataDir, FoundBlocksFile),
+		blocks:  make([]*FoundBlock, 0),
+		byHash:  make(map[string]*FoundBlock),
+	}
+}
+
+// Load reads the blocks recorded before, if any
+func (t *BlockTracker) Load() error {
+	b, err := ioutil.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	blocks := make([]*FoundBlock, 0)
+	err = json.Unmarshal(b, &blocks)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Timestamp < blocks[j].Timestamp
+	})
+	t.blocks = blocks
+	for _, fb := range blocks {
+		t.byHash[fb.Hash] = fb
+	}
+	t.trim()
+	log.Debugf("Loaded %d found blocks from disk", len(blocks))
+	return nil
+}
+
+// Save writes the recorded blocks to disk when they changed
+func (t *BlockTracker) Save() error {
+	t.lock.Lock()
+	if !t.dirty {
+		t.lock.Unlock()
+		return nil
+	}
+	b, err := json.MarshalIndent(t.blocks, "", "  ")
+	t.dirty = false
+	t.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(t.path), 0700)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(t.path+".new", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(t.path+".new", t.path)
+}
+
+// Add records s when it is a block that was not recorded before. Shares
+// arrive in any order, newest first when the chain is synced backwards, so
+// the block is inserted by its timestamp. Blocks no newer than all recorded
+// ones are ignored once the record is full, so that dropped blocks are not
+// added again.
+func (t *BlockTracker) Add(s *wire.Share) {
+	if !s.IsBlock() {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.byHash[s.Hash.String()]; ok {
+		return
+	}
+	if len(t.blocks) >= maxFoundBlocks && int64(s.ShareInfo.Timestamp) <= t.blocks[0].Timestamp {
+		return
+	}
+
+	fb := &FoundBlock{
+		Hash:      s.Hash.String(),
+		Height:    coinbaseHeight(s.ShareInfo.ShareData.CoinBase),
+		Share:     s.Hash.String(),
+		Timestamp: int64(s.ShareInfo.Timestamp),
+		Finder:    ShareAddress(s),
+		Reward:    s.ShareInfo.ShareData.Subsidy,
+		Status:    BlockPending,
+	}
+	i := sort.Search(len(t.blocks), func(i int) bool {
+		return t.blocks[i].Timestamp > fb.Timestamp
+	})
+	t.blocks = append(t.blocks, nil)
+	copy(t.blocks[i+1:], t.blocks[i:])
+	t.blocks[i] = fb
+	t.byHash[fb.Hash] = fb
+	t.trim()
+	t.dirty = true
+	metrics.FoundBlocks.Inc()
+	log.Info("Block found", "height", fb.Height, "hash", fb.Hash, "finder", fb.Finder)
+}
+
+// trim drops the oldest blocks beyond maxFoundBlocks. The caller holds
+// the lock.
+func (t *BlockTracker) trim() {
+	if len(t.blocks) <= maxFoundBlocks {
+		return
+	}
+	drop := len(t.blocks) - maxFoundBlocks
+	for _, fb := range t.blocks[:drop] {
+		delete(t.byHash, fb.Hash)
+	}
+	t.blocks = append(make([]*FoundBlock, 0, maxFoundBlocks), t.blocks[drop:]...)
+	t.dirty = true
+}
+
+// Blocks returns up to count of the most recently found blocks, newest
+// first
+func (t *BlockTracker) Blocks(count int) []FoundBlock {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	blocks := make([]FoundBlock, 0, count)
+	for i := len(t.blocks) - 1; i >= 0 && len(blocks) < count; i-- {
+		blocks = append(blocks, *t.blocks[i])
+	}
+	return blocks
+}
+
+// Follow checks the status of pending blocks with checker every interval,
+// until ctx is cancelled. Use Wait to block until it has stopped.
+func (t *BlockTracker) Follow(ctx context.Context, checker BlockChecker, interval time.Duration) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			t.check(ctx, checker)
+			err := t.Save()
+			if err != nil {
+				log.Errorf("Could not save found blocks: %s", err.Error())
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Wait blocks until Follow has stopped
+func (t *BlockTracker) Wait() {
+	t.wg.Wait()
+}
+
+func (t *BlockTracker) check(ctx context.Context, checker BlockChecker) {
+	t.lock.Lock()
+	pending := make([]FoundBlock, 0)
+	for _, fb := range t.blocks {
+		if fb.Status == BlockPending {
+			pending = append(pending, *fb)
+		}
+	}
+	t.lock.Unlock()
+
+	for _, fb := range pending {
+		hash, err := chainhash.NewHashFromStr(fb.Hash)
+		if err != nil {
+			continue
+		}
+		confirmations, found, err := checker.BlockConfirmations(ctx, hash)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warnf("Could not check found block %s: %s", fb.Hash, err.Error())
+			}
+			return
+		}
+
+		status := BlockPending
+		switch {
+		case !found:
+			confirmations = 0
+			if time.Since(time.Unix(fb.Timestamp, 0)) > unknownBlockTimeout {
+				status = BlockOrphaned
+			}
+		case confirmations < 0:
+			status = BlockOrphaned
+		case confirmations >= int64(t.network.CoinbaseMaturity):
+			status = BlockConfirmed
+		}
+		t.update(fb.Hash, status, confirmations)
+	}
+}
+
+func (t *BlockTracker) update(hash string, status BlockStatus, confirmations int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	fb := t.byHash[hash]
+	if fb.Status == status && fb.Confirmations == confirmations {
+		return
+	}
+	if fb.Status != status {
+		log.Info("Found block "+string(status), "height", fb.Height, "hash", fb.Hash, "confirmations", confirmations)
+	}
+	fb.Status = status
+	fb.Confirmations = confirmations
+	t.dirty = true
+}
+
+// coinbaseHeight reads the block height that BIP34 puts at the start of the
+// coinbase script, or returns 0 when it is not there.
+func coinbaseHeight(coinbase string) int64 {
+	if len(coinbase) < 1 {
+		return 0
+	}
+	n := int(coinbase[0])
+	if n < 1 || n > 8 || len(coinbase) < n+1 {
+		return 0
+	}
+	var height int64
+	for i := n; i >= 1; i-- {
+		height = height<<8 | int64(coinbase[i])
+	}
+	return height
+}
@@ -0,0 +1,141 @@
+package work_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// mineBlock builds shares on tmpl until one meets the block target
+func mineBlock(t *testing.T, n p2pnet.Network, tmpl sim.BlockTemplate) *wire.Share {
+	for {
+		s, err := sim.BuildShare(n, nil, tmpl, make([]byte, 20), wire.StaleInfoNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.IsBlock() {
+			return &s
+		}
+	}
+}
+
+func TestBlockTrackerShareTime(t *testing.T) {
+	n := p2pnet.Regtest()
+	fn := sim.NewFakeFullnode(0x207fffff, 50*100000000)
+	tracker := work.NewBlockTracker(n, t.TempDir())
+
+	// A block from a share loaded long after it was found keeps the time of
+	// the share, and as the fullnode never heard of it, it is orphaned
+	tmpl := fn.BlockTemplate()
+	tmpl.Timestamp -= uint32(2 * time.Hour / time.Second)
+	old := mineBlock(t, n, tmpl)
+	tracker.Add(old)
+	recent := mineBlock(t, n, fn.BlockTemplate())
+	tracker.Add(recent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracker.Follow(ctx, fn, time.Minute)
+	tracker.Wait()
+
+	blocks := tracker.Blocks(10)
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks, got %d", len(blocks))
+	}
+	if blocks[1].Hash != old.Hash.String() || blocks[1].Timestamp != int64(tmpl.Timestamp) {
+		t.Errorf("Expected block %s at %d, got %s at %d", old.Hash, tmpl.Timestamp, blocks[1].Hash, blocks[1].Timestamp)
+	}
+	if blocks[1].Status != work.BlockOrphaned {
+		t.Errorf("Expected the old unknown block orphaned, got %s", blocks[1].Status)
+	}
+	if blocks[0].Status != work.BlockPending {
+		t.Errorf("Expected the recent unknown block pending, got %s", blocks[0].Status)
+	}
+}
+
+func TestBlockTrackerLimit(t *testing.T) {
+	n := p2pnet.Regtest()
+	fn := sim.NewFakeFullnode(0x207fffff, 50*100000000)
+	dir := t.TempDir()
+	tracker := work.NewBlockTracker(n, dir)
+
+	tmpl := fn.BlockTemplate()
+	shares := make([]*wire.Share, 0)
+	for i := 0; i < work.MaxFoundBlocks+10; i++ {
+		tmpl.Timestamp++
+		s := mineBlock(t, n, tmpl)
+		shares = append(shares, s)
+		tracker.Add(s)
+	}
+
+	blocks := tracker.Blocks(2 * work.MaxFoundBlocks)
+	if len(blocks) != work.MaxFoundBlocks {
+		t.Fatalf("Expected %d blocks kept, got %d", work.MaxFoundBlocks, len(blocks))
+	}
+	if blocks[0].Hash != shares[len(shares)-1].Hash.String() || blocks[len(blocks)-1].Hash != shares[10].Hash.String() {
+		t.Errorf("Expected the newest blocks kept")
+	}
+
+	// A dropped block seen again is not recorded again
+	tracker.Add(shares[0])
+	blocks = tracker.Blocks(2 * work.MaxFoundBlocks)
+	if len(blocks) != work.MaxFoundBlocks || blocks[0].Hash != shares[len(shares)-1].Hash.String() {
+		t.Errorf("Expected the dropped block to be ignored")
+	}
+
+	err := tracker.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := work.NewBlockTracker(n, dir)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Blocks(2*work.MaxFoundBlocks)) != work.MaxFoundBlocks {
+		t.Errorf("Expected %d blocks after loading", work.MaxFoundBlocks)
+	}
+}
+
+func TestBlockTrackerNewestFirst(t *testing.T) {
+	n := p2pnet.Regtest()
+	fn := sim.NewFakeFullnode(0x207fffff, 50*100000000)
+	tracker := work.NewBlockTracker(n, t.TempDir())
+
+	// Syncing the chain backwards adds the blocks newest first
+	tmpl := fn.BlockTemplate()
+	count := work.MaxFoundBlocks + 10
+	shares := make([]*wire.Share, count)
+	for i := count - 1; i >= 0; i-- {
+		tmpl.Timestamp = fn.BlockTemplate().Timestamp - 2*uint32(count-i)
+		shares[i] = mineBlock(t, n, tmpl)
+	}
+	for i := count - 1; i >= 0; i-- {
+		tracker.Add(shares[i])
+	}
+
+	blocks := tracker.Blocks(2 * work.MaxFoundBlocks)
+	if len(blocks) != work.MaxFoundBlocks {
+		t.Fatalf("Expected %d blocks kept, got %d", work.MaxFoundBlocks, len(blocks))
+	}
+	for i, fb := range blocks {
+		expected := shares[count-1-i]
+		if fb.Hash != expected.Hash.String() {
+			t.Fatalf("Expected block %d to be %s at %d, got %s at %d", i, expected.Hash, expected.ShareInfo.Timestamp, fb.Hash, fb.Timestamp)
+		}
+	}
+
+	// A block found in between is kept in order
+	tmpl.Timestamp = uint32(blocks[1].Timestamp) + 1
+	between := mineBlock(t, n, tmpl)
+	tracker.Add(between)
+	blocks = tracker.Blocks(3)
+	if blocks[0].Hash != shares[count-1].Hash.String() || blocks[1].Hash != between.Hash.String() || blocks[2].Hash != shares[count-2].Hash.String() {
+		t.Errorf("Expected the new block between the two newest blocks")
+	}
+}
@@ -0,0 +1,11 @@
+package work
+
+// MaxFoundBlocks exposes the number of found blocks a tracker keeps
+const MaxFoundBlocks = maxFoundBlocks
+
+// OwnShareCount returns the number of own shares not yet settled
+func (sc *ShareChain) OwnShareCount() int {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+	return len(sc.ownShares)
+}
@@ -0,0 +1,66 @@
+package work
+
+import (
+	"math/big"
+)
+
+// HashRate is an estimated hashrate in hashes per second
+type HashRate struct {
+	// Nonstale is the rate of the work that made it into the chain
+	Nonstale float64 `json:"nonstale"`
+	// Total includes the work lost to stale shares
+	Total float64 `json:"total"`
+}
+
+// newHashRate converts work done in seconds to a hashrate, corrected for
+// the stale shares in counts
+func newHashRate(work *big.Int, seconds int64, counts StaleCounts) HashRate {
+	rate, _ := new(big.Float).Quo(new(big.Float).SetInt(work), new(big.Float).SetInt64(seconds)).Float64()
+	return HashRate{Nonstale: rate, Total: rate / (1 - counts.StaleProp())}
+}
+
+// GetHashRates estimates the hashrate of the pool and of every payout address
+// from the targets and timestamps of the last window shares up to the tip.
+// Like the reference implementation, the work of all but the oldest share is
+// divided by the time between the oldest share and the tip. The rates are
+// zero when there are fewer than two shares.
+func (sc *ShareChain) GetHashRates(window int) (HashRate, map[string]HashRate) {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	perAddress := map[string]HashRate{}
+	if sc.Tip == nil || sc.Tip.Previous == nil || window < 2 {
+		return HashRate{}, perAddress
+	}
+
+	poolWork := big.NewInt(0)
+	pool := StaleCounts{}
+	addrWork := map[string]*big.Int{}
+	addrCounts := map[string]StaleCounts{}
+	s := sc.Tip
+	for i := 0; s.Previous != nil && i < window-1; i++ {
+		w := ShareWork(s.Share)
+		si := s.Share.ShareInfo.ShareData.StaleInfo
+		poolWork.Add(poolWork, w)
+		pool.add(si)
+
+		addr := ShareAddress(s.Share)
+		if _, ok := addrWork[addr]; !ok {
+			addrWork[addr] = big.NewInt(0)
+		}
+		addrWork[addr].Add(addrWork[addr], w)
+		c := addrCounts[addr]
+		c.add(si)
+		addrCounts[addr] = c
+		s = s.Previous
+	}
+
+	seconds := int64(sc.Tip.Share.ShareInfo.Timestamp) - int64(s.Share.ShareInfo.Timestamp)
+	if seconds <= 0 {
+		seconds = 1
+	}
+	for addr, w := range addrWork {
+		perAddress[addr] = newHashRate(w, seconds, addrCounts[addr])
+	}
+	return newHashRate(poolWork, seconds, pool), perAddress
+}
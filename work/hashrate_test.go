@@ -0,0 +1,85 @@
+package work_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+func expectRate(t *testing.T, name string, got work.HashRate, nonstale, total float64) {
+	t.Helper()
+	if math.Abs(got.Nonstale-nonstale) > 1e-9 || math.Abs(got.Total-total) > 1e-9 {
+		t.Errorf("Expected %s hashrate %g (%g with stales), got %g (%g)", name, nonstale, total, got.Nonstale, got.Total)
+	}
+}
+
+func TestGetHashRates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	sc := work.NewShareChain(ctx, n, t.TempDir())
+	defer func() {
+		cancel()
+		sc.Wait()
+	}()
+	tmpl := sim.NewFakeFullnode(0x207fffff, 50*100000000).BlockTemplate()
+
+	// Six shares ten seconds apart, alternating between two addresses. The
+	// fifth one announces an orphan of the first address. Shares at the
+	// regtest maximum target take 2 attempts on average.
+	a, b := make([]byte, 20), make([]byte, 20)
+	b[0] = 1
+	start := tmpl.Timestamp
+	var prev *wire.Share
+	for i := 0; i < 6; i++ {
+		pubKeyHash, si := a, wire.StaleInfoNone
+		if i%2 == 1 {
+			pubKeyHash = b
+		}
+		if i == 4 {
+			si = wire.StaleInfoOrphan
+		}
+		tmpl.Timestamp = start + uint32(10*i)
+		s, err := sim.BuildShare(n, prev, tmpl, pubKeyHash, si)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := work.ShareWork(&s); w.Int64() != 2 {
+			t.Fatalf("Expected 2 attempts per share, got %s", w)
+		}
+		sc.AddShares([]wire.Share{s})
+		prev = &s
+	}
+	addrA := work.ShareAddress(sc.GetRecentShares(6)[5])
+	addrB := work.ShareAddress(sc.GetRecentShares(1)[0])
+
+	// The work of the five newest shares over the 50 seconds since the
+	// oldest one. One orphan among six mined shares makes the total 6/5 of
+	// that.
+	pool, perAddress := sc.GetHashRates(6)
+	expectRate(t, "pool", pool, 10.0/50, 10.0/50*6/5)
+	expectRate(t, "first address", perAddress[addrA], 4.0/50, 4.0/50*3/2)
+	expectRate(t, "second address", perAddress[addrB], 6.0/50, 6.0/50)
+	if len(perAddress) != 2 {
+		t.Errorf("Expected 2 addresses, got %d", len(perAddress))
+	}
+
+	// A window longer than the chain covers the whole chain
+	pool, _ = sc.GetHashRates(100)
+	expectRate(t, "pool over a long window", pool, 10.0/50, 10.0/50*6/5)
+
+	// The last three shares: two counted over 20 seconds, one of them with
+	// the orphan
+	pool, perAddress = sc.GetHashRates(3)
+	expectRate(t, "pool over 3 shares", pool, 4.0/20, 4.0/20*3/2)
+	expectRate(t, "first address over 3 shares", perAddress[addrA], 2.0/20, 2.0/20*2)
+
+	pool, perAddress = sc.GetHashRates(1)
+	if pool != (work.HashRate{}) || len(perAddress) != 0 {
+		t.Errorf("Expected no hashrate from a single share, got %+v", pool)
+	}
+}
@@ -0,0 +1,5 @@
+package work
+
+import "github.com/gertjaap/p2pool-go/logging"
+
+var log = logging.New("work")
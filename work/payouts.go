@@ -0,0 +1,126 @@
+package work
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+type Payouts struct {
+	// Amounts paid to each address
+	Amounts map[string]uint64
+	// Amount paid to the donation script
+	Donation uint64
+}
+
+// ShareAddress returns the payout address of a share
+func ShareAddress(s *wire.Share) string {
+	return base58.CheckEncode(s.ShareInfo.ShareData.PubKeyHash, s.ShareInfo.ShareData.PubKeyHashVersion)
+}
+
+// cumulativeWeights walks back from start over at most maxShares shares and
+// adds up the work of each share per address, until desiredWeight is reached.
+// The last share only counts for the part that fits. The caller must hold
+// allSharesLock.
+func cumulativeWeights(start *ChainShare, maxShares int, desiredWeight *big.Int) (weights map[string]*big.Int, totalWeight *big.Int, donationWeight *big.Int) {
+	weights = map[string]*big.Int{}
+	totalWeight = big.NewInt(0)
+	donationWeight = big.NewInt(0)
+
+	s := start
+	for i := 0; s != nil && i < maxShares && totalWeight.Cmp(desiredWeight) < 0; i++ {
+		att := TargetToAverageAttempts(blockchain.CompactToBig(uint32(s.Share.ShareInfo.Bits)))
+		donation := int64(s.Share.ShareInfo.ShareData.Donation)
+		weight := big.NewInt(0).Mul(att, big.NewInt(65535-donation))
+		shareDonation := big.NewInt(0).Mul(att, big.NewInt(donation))
+		shareTotal := big.NewInt(0).Mul(att, big.NewInt(65535))
+
+		if big.NewInt(0).Add(totalWeight, shareTotal).Cmp(desiredWeight) > 0 {
+			// Only count the part of the last share that fits
+			remaining := big.NewInt(0).Sub(desiredWeight, totalWeight)
+			weight.Mul(weight, remaining).Div(weight, shareTotal)
+			shareDonation.Mul(shareDonation, remaining).Div(shareDonation, shareTotal)
+			shareTotal = remaining
+		}
+
+		addr := ShareAddress(s.Share)
+		if _, ok := weights[addr]; !ok {
+			weights[addr] = big.NewInt(0)
+		}
+		weights[addr].Add(weights[addr], weight)
+		donationWeight.Add(donationWeight, shareDonation)
+		totalWeight.Add(totalWeight, shareTotal)
+		s = s.Previous
+	}
+	return weights, totalWeight, donationWeight
+}
+
+// GetPayouts calculates how the block reward would be divided if the share
+// with the given hash found a block. Like the reference implementation, the
+// shares preceding it are weighted by their work up to SPREAD times the block
+// work, 0.5% of the subsidy goes to the finder and rounding leftovers go to
+// the donation script.
+func (sc *ShareChain) GetPayouts(hash *chainhash.Hash) (Payouts, error) {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	p := Payouts{Amounts: map[string]uint64{}}
+	cs, ok := sc.AllShares[hash.String()]
+	if !ok {
+		return p, fmt.Errorf("Unknown share %s", hash.String())
+	}
+	subsidy := cs.Share.ShareInfo.ShareData.Subsidy
+
+	blockAttempts := TargetToAverageAttempts(blockchain.CompactToBig(cs.Share.MinHeader.Bits))
+	desiredWeight := big.NewInt(65535 * int64(sc.network.Spread))
+	desiredWeight.Mul(desiredWeight, blockAttempts)
+
+	weights, totalWeight, _ := cumulativeWeights(cs.Previous, sc.network.RealChainLength-1, desiredWeight)
+
+	paid := uint64(0)
+	if totalWeight.Sign() > 0 {
+		for addr, weight := range weights {
+			amount := big.NewInt(0).SetUint64(subsidy)
+			amount.Mul(amount, big.NewInt(199))
+			amount.Mul(amount, weight)
+			amount.Div(amount, big.NewInt(0).Mul(big.NewInt(200), totalWeight))
+			p.Amounts[addr] = amount.Uint64()
+			paid += amount.Uint64()
+		}
+	}
+
+	finder := ShareAddress(cs.Share)
+	p.Amounts[finder] += subsidy / 200
+	paid += subsidy / 200
+	p.Donation = subsidy - paid
+	return p, nil
+}
+
+// GetUserWeights returns the fraction of the work in the last maxShares
+// shares before the tip that each address contributed, and the fraction that
+// went to donations.
+func (sc *ShareChain) GetUserWeights(maxShares int) (map[string]float64, float64) {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	res := map[string]float64{}
+	if sc.Tip == nil {
+		return res, 0
+	}
+	unlimited := big.NewInt(1)
+	unlimited.Lsh(unlimited, 256+16)
+	weights, totalWeight, donationWeight := cumulativeWeights(sc.Tip, maxShares, unlimited)
+	if totalWeight.Sign() == 0 {
+		return res, 0
+	}
+	total := new(big.Float).SetInt(totalWeight)
+	for addr, weight := range weights {
+		res[addr], _ = new(big.Float).Quo(new(big.Float).SetInt(weight), total).Float64()
+	}
+	donation, _ := new(big.Float).Quo(new(big.Float).SetInt(donationWeight), total).Float64()
+	return res, donation
+}
@@ -0,0 +1,170 @@
+package work
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// The reference implementation keeps its shares in data/<net>/shares.0,
+// shares.1 and so on. Every line in these files is a record type and hex
+// data separated by a space. Type 5 holds a share packed as its type and
+// length prefixed contents, exactly like a share in a shares message, type 2
+// holds the hash of a share that has been verified and types 0 and 1 are no
+// longer used.
+const (
+	pythonRecordShare    = 5
+	pythonRecordVerified = 2
+)
+
+var pythonShareFile = regexp.MustCompile(`^shares\.(\d+)$`)
+
+// PythonImport holds the result of reading a share store of the reference
+// implementation
+type PythonImport struct {
+	Files  int
+	Shares []wire.Share
+	// Problems found in records that were skipped, like the reference
+	// implementation does when loading its store
+	Skipped []error
+}
+
+// IsPythonShareStore returns true when path is a directory containing
+// shares.N files, or a shares.N file itself.
+func IsPythonShareStore(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if !fi.IsDir() {
+		return pythonShareFile.MatchString(filepath.Base(path))
+	}
+	files, _ := pythonShareFiles(path)
+	return len(files) > 0
+}
+
+// pythonShareFiles returns the shares.N files in dir, oldest first
+func pythonShareFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type numbered struct {
+		n    int
+		name string
+	}
+	found := make([]numbered, 0)
+	for _, e := range entries {
+		m := pythonShareFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		i, _ := strconv.Atoi(m[1])
+		found = append(found, numbered{i, filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].n < found[j].n })
+	files := make([]string, len(found))
+	for i := range found {
+		files[i] = found[i].name
+	}
+	return files, nil
+}
+
+// ReadPythonShareStore reads the shares from the share store of the reference
+// implementation at path, either a data/<net> directory or a single shares.N
+// file. Records that cannot be decoded, and shares older than the minimum
+// share version of network n, are skipped.
+func ReadPythonShareStore(path string, n p2pnet.Network) (*PythonImport, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if fi.IsDir() {
+		files, err = pythonShareFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("No shares.N files found in %s", path)
+		}
+	}
+
+	res := &PythonImport{Shares: make([]wire.Share, 0), Skipped: make([]error, 0)}
+	seen := map[string]bool{}
+	for _, file := range files {
+		err = readPythonShareFile(file, n, res, seen)
+		if err != nil {
+			return nil, err
+		}
+		res.Files++
+	}
+	return res, nil
+}
+
+func readPythonShareFile(file string, n p2pnet.Network, res *PythonImport, seen map[string]bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// A share with all its new transaction hashes can be a long line
+	scanner.Buffer(make([]byte, 0, 64*1024), wire.MaxPayloadLength*2+16)
+	line := 0
+	for scanner.Scan() {
+		line++
+		skip := func(format string, args ...interface{}) {
+			res.Skipped = append(res.Skipped, fmt.Errorf("%s:%d: %s", file, line, fmt.Sprintf(format, args...)))
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			skip("malformed record")
+			continue
+		}
+		recordType, err := strconv.Atoi(fields[0])
+		if err != nil {
+			skip("malformed record type %s", fields[0])
+			continue
+		}
+		if recordType != pythonRecordShare {
+			if recordType != pythonRecordVerified && recordType != 0 && recordType != 1 {
+				skip("unknown record type %d", recordType)
+			}
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[1])
+		if err != nil {
+			skip("malformed share data: %s", err.Error())
+			continue
+		}
+		s, err := wire.ReadShare(bytes.NewReader(b), n)
+		if err != nil {
+			skip("could not decode share: %s", err.Error())
+			continue
+		}
+		if seen[s.Hash.String()] {
+			continue
+		}
+		seen[s.Hash.String()] = true
+		res.Shares = append(res.Shares, s)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,68 @@
+package work_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// The store in testdata/pystore holds three regtest shares, each building on
+// the previous one, spread over shares.0, shares.2 and shares.10. Next to
+// them are records of the unused types 0 and 1, verified records of type 2,
+// a duplicate and malformed records.
+var pystoreHashes = []string{
+	"6c9aa841a785d282cd0a87d4decd6a4c6d855d87fbd417485330d40ff18548e7",
+	"13633a658579bb4ba5aded2cd74ae8c0b963621ca61e4175eb15b6ed439f25dc",
+	"465c3c05fa91664c983703b91984f3f7c93281095f0f304421a9bd27cbce4197",
+}
+
+func TestReadPythonShareStore(t *testing.T) {
+	dir := filepath.Join("testdata", "pystore")
+	if !work.IsPythonShareStore(dir) {
+		t.Fatalf("%s not recognized as a share store", dir)
+	}
+	res, err := work.ReadPythonShareStore(dir, p2pnet.Regtest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 3 {
+		t.Errorf("Expected 3 files, read %d", res.Files)
+	}
+	// shares.10 is read after shares.2
+	if len(res.Shares) != len(pystoreHashes) {
+		t.Fatalf("Expected %d shares, got %d", len(pystoreHashes), len(res.Shares))
+	}
+	for i, s := range res.Shares {
+		if s.Hash.String() != pystoreHashes[i] {
+			t.Errorf("Expected share %d to be %s, got %s", i, pystoreHashes[i], s.Hash)
+		}
+		if !s.IsValid() {
+			t.Errorf("Share %s is not valid", s.Hash)
+		}
+	}
+	// A line without data, an invalid type, invalid hex and an unknown type
+	// in shares.0, and a share that does not decode in shares.2
+	if len(res.Skipped) != 5 {
+		t.Errorf("Expected 5 skipped records, got %d: %v", len(res.Skipped), res.Skipped)
+	}
+}
+
+func TestReadPythonShareFile(t *testing.T) {
+	file := filepath.Join("testdata", "pystore", "shares.10")
+	if !work.IsPythonShareStore(file) {
+		t.Fatalf("%s not recognized as a share store", file)
+	}
+	res, err := work.ReadPythonShareStore(file, p2pnet.Regtest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 1 || len(res.Shares) != 1 || res.Shares[0].Hash.String() != pystoreHashes[2] || len(res.Skipped) != 0 {
+		t.Fatalf("Expected only share %s, got %d files, %d shares, skipped %v", pystoreHashes[2], res.Files, len(res.Shares), res.Skipped)
+	}
+
+	if work.IsPythonShareStore("testdata") {
+		t.Error("Directory without shares.N files recognized as a share store")
+	}
+}
@@ -1,16 +1,24 @@
 package work
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/gertjaap/p2pool-go/logging"
+	"github.com/gertjaap/p2pool-go/metrics"
 	p2pnet "github.com/gertjaap/p2pool-go/net"
 	"github.com/gertjaap/p2pool-go/wire"
 )
 
+// ShareChainFile is the name of the file the share chain is stored in, inside
+// the network data directory
+const ShareChainFile = "sharechain.dat"
+
 type ShareChain struct {
 	SharesChannel    chan []wire.Share
 	NeedShareChannel chan *chainhash.Hash
@@ -18,10 +26,22 @@ type ShareChain struct {
 	Tail             *ChainShare
 	AllShares        map[string]*ChainShare
 	AllSharesByPrev  map[string]*ChainShare
+	// Blocks records the shares that are blocks
+	Blocks *BlockTracker
+
+	// ownShares holds the shares this node mined that are not yet deeper
+	// than the chain length, by hash. ownSettled counts the ones that are.
+	ownShares  map[string]ownShare
+	ownSettled OwnStaleCounts
 
 	disconnectedShares    []*wire.Share
 	disconnectedShareLock sync.Mutex
 	allSharesLock         sync.Mutex
+
+	ctx     context.Context
+	wg      sync.WaitGroup
+	network p2pnet.Network
+	dataDir string
 }
 
 type ChainShare struct {
@@ -30,38 +50,79 @@ type ChainShare struct {
 	Next     *ChainShare
 }
 
-func NewShareChain() *ShareChain {
-	sc := &ShareChain{disconnectedShares: make([]*wire.Share, 0), allSharesLock: sync.Mutex{}, AllSharesByPrev: map[string]*ChainShare{}, AllShares: map[string]*ChainShare{}, disconnectedShareLock: sync.Mutex{}, SharesChannel: make(chan []wire.Share, 10), NeedShareChannel: make(chan *chainhash.Hash, 10)}
+// NewShareChain creates an empty share chain and starts processing incoming
+// shares for network n. The chain is stored in dataDir. When ctx is
+// cancelled the chain is committed to disk one final time; use Wait to block
+// until that has completed.
+func NewShareChain(ctx context.Context, n p2pnet.Network, dataDir string) *ShareChain {
+	sc := &ShareChain{ctx: ctx, network: n, dataDir: dataDir, disconnectedShares: make([]*wire.Share, 0), allSharesLock: sync.Mutex{}, AllSharesByPrev: map[string]*ChainShare{}, AllShares: map[string]*ChainShare{}, disconnectedShareLock: sync.Mutex{}, SharesChannel: make(chan []wire.Share, 10), NeedShareChannel: make(chan *chainhash.Hash, 10)}
+	sc.Blocks = NewBlockTracker(n, dataDir)
+	sc.ownShares = make(map[string]ownShare)
+	sc.wg.Add(1)
 	go sc.ReadShareChan()
 	return sc
 }
 
 func (sc *ShareChain) ReadShareChan() {
-	for s := range sc.SharesChannel {
-		sc.AddShares(s)
+	defer sc.wg.Done()
+	for {
+		select {
+		case s := <-sc.SharesChannel:
+			sc.AddShares(s)
+		case <-sc.ctx.Done():
+			if sc.Tip == nil {
+				return
+			}
+			err := sc.Commit()
+			if err != nil {
+				log.Errorf("Could not commit sharechain: %s", err.Error())
+			}
+			return
+		}
 	}
 }
 
+// Wait blocks until the share chain has stopped processing shares and has
+// been written to disk.
+func (sc *ShareChain) Wait() {
+	sc.wg.Wait()
+}
+
 func (sc *ShareChain) AddChainShare(newChainShare *ChainShare) {
 	sc.allSharesLock.Lock()
+	sc.addChainShare(newChainShare)
+	sc.allSharesLock.Unlock()
+}
+
+// addChainShare expects the caller to hold allSharesLock
+func (sc *ShareChain) addChainShare(newChainShare *ChainShare) {
 	sc.AllShares[newChainShare.Share.Hash.String()] = newChainShare
 	sc.AllSharesByPrev[newChainShare.Share.ShareInfo.ShareData.PreviousShareHash.String()] = newChainShare
-	sc.allSharesLock.Unlock()
+	sc.Blocks.Add(newChainShare.Share)
 }
 
 func (sc *ShareChain) Resolve(skipCommit bool) {
-	logging.Debugf("Resolving sharechain")
+	log.Debugf("Resolving sharechain")
 	if len(sc.disconnectedShares) == 0 {
 		return
 	}
 
+	sc.allSharesLock.Lock()
 	if sc.Tip == nil {
+		// Start from the share with the most work, the others connect to
+		// it or are fetched until they do
 		sc.disconnectedShareLock.Lock()
-		newChainShare := &ChainShare{Share: sc.disconnectedShares[0]}
+		best := 0
+		for i, s := range sc.disconnectedShares {
+			if MoreWork(s.ShareInfo.AbsWork, sc.disconnectedShares[best].ShareInfo.AbsWork) {
+				best = i
+			}
+		}
+		newChainShare := &ChainShare{Share: sc.disconnectedShares[best]}
 		sc.Tip = newChainShare
-		sc.disconnectedShares = sc.disconnectedShares[1:]
+		sc.disconnectedShares = append(sc.disconnectedShares[:best], sc.disconnectedShares[best+1:]...)
 		sc.disconnectedShareLock.Unlock()
-		sc.AddChainShare(newChainShare)
+		sc.addChainShare(newChainShare)
 		sc.Tail = sc.Tip
 	}
 
@@ -78,12 +139,19 @@ func (sc *ShareChain) Resolve(skipCommit bool) {
 
 			es, ok := sc.AllShares[s.ShareInfo.ShareData.PreviousShareHash.String()]
 			if ok {
+				if expected := ExpectedAbsWork(es.Share, s); expected.Cmp(s.ShareInfo.AbsWork) != 0 {
+					log.Warnf("Ignoring share %s: absolute work %s does not follow its previous share, expected %s", s.Hash.String(), s.ShareInfo.AbsWork.String(), expected.String())
+					metrics.InvalidShares.WithLabelValues(metrics.InvalidReasonWork).Inc()
+					continue
+				}
 				newChainShare := &ChainShare{Share: s, Previous: es}
-				es.Next = newChainShare
-				if es.Share.Hash.IsEqual(sc.Tip.Share.Hash) {
-					sc.Tip = newChainShare
+				if es.Next == nil {
+					es.Next = newChainShare
+				}
+				sc.addChainShare(newChainShare)
+				if MoreWork(s.ShareInfo.AbsWork, sc.Tip.Share.ShareInfo.AbsWork) {
+					sc.setTip(newChainShare)
 				}
-				sc.AddChainShare(newChainShare)
 				extended = true
 			} else {
 				es, ok := sc.AllSharesByPrev[s.Hash.String()]
@@ -93,7 +161,7 @@ func (sc *ShareChain) Resolve(skipCommit bool) {
 					if es.Share.Hash.IsEqual(sc.Tail.Share.Hash) {
 						sc.Tail = newChainShare
 					}
-					sc.AddChainShare(newChainShare)
+					sc.addChainShare(newChainShare)
 					extended = true
 				} else {
 					newDisconnectedShares = append(newDisconnectedShares, s)
@@ -108,19 +176,108 @@ func (sc *ShareChain) Resolve(skipCommit bool) {
 			break
 		}
 	}
+	metrics.ShareChainHeight.Set(float64(len(sc.AllShares)))
+	metrics.DisconnectedShares.Set(float64(len(sc.disconnectedShares)))
+	metrics.SetTipTime(time.Unix(int64(sc.Tip.Share.ShareInfo.Timestamp), 0))
+	sc.allSharesLock.Unlock()
 
-	logging.Debugf("Tip is now %s - disconnected: %d - Length: %d", sc.Tip.Share.Hash.String(), len(sc.disconnectedShares), len(sc.AllShares))
+	log.Debugf("Tip is now %s - disconnected: %d - Length: %d", sc.Tip.Share.Hash.String(), len(sc.disconnectedShares), len(sc.AllShares))
 
-	if len(sc.AllShares) < p2pnet.ActiveNetwork.ChainLength {
-		sc.NeedShareChannel <- sc.Tail.Share.ShareInfo.ShareData.PreviousShareHash
+	tailPrev := sc.Tail.Share.ShareInfo.ShareData.PreviousShareHash
+	if len(sc.AllShares) < sc.network.ChainLength && !isNullHash(tailPrev) {
+		select {
+		case sc.NeedShareChannel <- tailPrev:
+		case <-sc.ctx.Done():
+		}
+	}
+	if missing := sc.missingForkShare(); missing != nil {
+		select {
+		case sc.NeedShareChannel <- missing:
+		case <-sc.ctx.Done():
+		}
 	}
 	if !skipCommit {
-		sc.Commit()
+		err := sc.Commit()
+		if err != nil {
+			log.Errorf("Could not commit sharechain: %s", err.Error())
+		}
+	}
+}
+
+// setTip makes cs the tip of the chain. When cs is on a fork, the Next links
+// of its branch are pointed at cs so walking forward follows the new chain.
+// The caller must hold allSharesLock.
+func (sc *ShareChain) setTip(cs *ChainShare) {
+	old := sc.Tip
+	sc.Tip = cs
+	if cs.Previous == old {
+		old.Next = cs
+		return
+	}
+
+	mainChain := make(map[*ChainShare]bool)
+	for c := old; c != nil; c = c.Previous {
+		mainChain[c] = true
+	}
+	depth := 0
+	for c := cs; c.Previous != nil && !mainChain[c]; c = c.Previous {
+		c.Previous.Next = c
+		depth++
+	}
+	log.Infof("Switched to share %s with more work than %s, %d shares reorganized", cs.Share.Hash.String(), old.Share.Hash.String(), depth)
+}
+
+// missingForkShare returns the hash of the share needed to connect the
+// disconnected shares with the most work to the chain, when they have more
+// work than the tip. This is how a node that started on a stale chain finds
+// its way to the chain of the network.
+func (sc *ShareChain) missingForkShare() *chainhash.Hash {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+	sc.disconnectedShareLock.Lock()
+	defer sc.disconnectedShareLock.Unlock()
+	if sc.Tip == nil {
+		return nil
+	}
+
+	var best *wire.Share
+	byHash := make(map[chainhash.Hash]*wire.Share, len(sc.disconnectedShares))
+	for _, s := range sc.disconnectedShares {
+		byHash[*s.Hash] = s
+		if MoreWork(s.ShareInfo.AbsWork, sc.Tip.Share.ShareInfo.AbsWork) && (best == nil || MoreWork(s.ShareInfo.AbsWork, best.ShareInfo.AbsWork)) {
+			best = s
+		}
+	}
+	if best == nil {
+		return nil
 	}
+	for i := 0; i < len(byHash); i++ {
+		prev, ok := byHash[*orNull(best.ShareInfo.ShareData.PreviousShareHash)]
+		if !ok {
+			break
+		}
+		best = prev
+	}
+	prevHash := best.ShareInfo.ShareData.PreviousShareHash
+	if isNullHash(prevHash) {
+		return nil
+	}
+	return prevHash
+}
+
+// GetWork returns the absolute work of the share with the given hash, or nil
+// if it is not in the chain.
+func (sc *ShareChain) GetWork(hash *chainhash.Hash) *big.Int {
+	s := sc.GetShare(hash)
+	if s == nil {
+		return nil
+	}
+	return s.ShareInfo.AbsWork
 }
 
 func (sc *ShareChain) Commit() error {
 	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
 
 	shares := make([]wire.Share, 0)
 	i := 0
@@ -130,39 +287,49 @@ func (sc *ShareChain) Commit() error {
 		s = s.Previous
 		i++
 	}
-	f, err := os.Create("sharechain-new.dat")
+	err := os.MkdirAll(sc.dataDir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(sc.path("sharechain-new.dat"))
 	if err != nil {
 		return err
 	}
 
-	wire.WriteShares(f, shares)
-
-	f.Close()
-
-	if _, err := os.Stat("sharechain.dat"); err == nil {
-		err = os.Remove("sharechain.dat")
-		if err != nil {
-			return err
-		}
+	err = wire.WriteShares(f, sc.network, shares)
+	if err != nil {
+		f.Close()
+		return err
 	}
 
-	os.Rename("sharechain-new.dat", "sharechain.dat")
+	err = f.Close()
+	if err != nil {
+		return err
+	}
 
-	sc.allSharesLock.Unlock()
-	return nil
+	err = os.Rename(sc.path("sharechain-new.dat"), sc.path(ShareChainFile))
+	if err != nil {
+		return err
+	}
+	return sc.Blocks.Save()
 }
 
 func (sc *ShareChain) Load() error {
+	err := sc.Blocks.Load()
+	if err != nil {
+		return fmt.Errorf("Could not load found blocks: %s", err.Error())
+	}
 
-	if _, err := os.Stat("sharechain.dat"); os.IsNotExist(err) {
+	if _, err := os.Stat(sc.path(ShareChainFile)); os.IsNotExist(err) {
 		return nil // Sharechain data absent, no need to do anything then.
 	}
 
-	f, err := os.Open("sharechain.dat")
+	f, err := os.Open(sc.path(ShareChainFile))
 	if err != nil {
 		return err
 	}
-	shares, err := wire.ReadShares(f)
+	defer f.Close()
+	shares, err := wire.ReadShares(f, sc.network)
 	if err != nil {
 		return err
 	}
@@ -180,7 +347,7 @@ func (sc *ShareChain) Load() error {
 	}
 	sc.disconnectedShareLock.Unlock()
 
-	logging.Debugf("Loaded %d shares from disk", len(sc.disconnectedShares))
+	log.Debugf("Loaded %d shares from disk", len(sc.disconnectedShares))
 
 	sc.Resolve(true)
 
@@ -190,6 +357,7 @@ func (sc *ShareChain) Load() error {
 func (sc *ShareChain) AddShares(s []wire.Share) {
 	// Decode
 
+	sc.allSharesLock.Lock()
 	sc.disconnectedShareLock.Lock()
 	for i := range s {
 		if s[i].IsValid() {
@@ -198,17 +366,101 @@ func (sc *ShareChain) AddShares(s []wire.Share) {
 				sc.disconnectedShares = append(sc.disconnectedShares, &s[i])
 			}
 		} else {
-			logging.Warnf("Ignoring invalid share %s", s[i].Hash.String())
+			log.Warnf("Ignoring invalid share %s", s[i].Hash.String())
+			metrics.InvalidShares.WithLabelValues(metrics.InvalidReasonPOW).Inc()
 		}
 	}
 	sc.disconnectedShareLock.Unlock()
+	sc.allSharesLock.Unlock()
 
 	sc.Resolve(false)
 }
 
+func (sc *ShareChain) path(name string) string {
+	return filepath.Join(sc.dataDir, name)
+}
+
 func (sc *ShareChain) GetTipHash() *chainhash.Hash {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
 	if sc.Tip != nil {
 		return sc.Tip.Share.Hash
 	}
 	return nil
 }
+
+// GetTip returns the share at the tip of the chain, or nil if the chain is
+// empty.
+func (sc *ShareChain) GetTip() *wire.Share {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+	if sc.Tip != nil {
+		return sc.Tip.Share
+	}
+	return nil
+}
+
+// GetShare returns the share with the given hash, or nil if it is not in the
+// chain.
+func (sc *ShareChain) GetShare(hash *chainhash.Hash) *wire.Share {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+	cs, ok := sc.AllShares[hash.String()]
+	if !ok {
+		return nil
+	}
+	return cs.Share
+}
+
+// GetRecentShares returns up to count shares, starting at the tip and going
+// back in the chain.
+func (sc *ShareChain) GetRecentShares(count int) []*wire.Share {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	shares := make([]*wire.Share, 0, count)
+	for s := sc.Tip; s != nil && len(shares) < count; s = s.Previous {
+		shares = append(shares, s.Share)
+	}
+	return shares
+}
+
+// GetShareCount returns the number of shares in the chain
+func (sc *ShareChain) GetShareCount() int {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+	return len(sc.AllShares)
+}
+
+// GetShares returns the shares with the given hashes, each followed by up to
+// parents of its predecessors. Walking back from a hash stops at any of the
+// hashes in stops.
+func (sc *ShareChain) GetShares(hashes []*chainhash.Hash, parents uint64, stops []*chainhash.Hash) ([]wire.Share, error) {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	stopSet := map[string]bool{}
+	for _, h := range stops {
+		stopSet[h.String()] = true
+	}
+
+	shares := make([]wire.Share, 0)
+	for _, h := range hashes {
+		s, ok := sc.AllShares[h.String()]
+		if !ok {
+			return nil, fmt.Errorf("Unknown share %s", h.String())
+		}
+		for i := uint64(0); s != nil && i <= parents; i++ {
+			if stopSet[s.Share.Hash.String()] {
+				break
+			}
+			shares = append(shares, *s.Share)
+			s = s.Previous
+		}
+	}
+	return shares, nil
+}
+
+func isNullHash(h *chainhash.Hash) bool {
+	return h == nil || h.IsEqual(&chainhash.Hash{})
+}
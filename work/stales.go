@@ -0,0 +1,209 @@
+package work
+
+import (
+	"time"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// WindowShares returns the number of shares the network is expected to
+// produce in window, and at least one.
+func WindowShares(n p2pnet.Network, window time.Duration) int {
+	shares := int(window / (time.Duration(n.SharePeriod) * time.Second))
+	if shares < 1 {
+		return 1
+	}
+	return shares
+}
+
+// StaleCounts counts the shares in part of the chain and the stale shares
+// they announced in their StaleInfo. Stale shares are not in the chain
+// themselves; the next share of the same miner reports them.
+type StaleCounts struct {
+	Shares int `json:"shares"`
+	Orphan int `json:"orphan"`
+	DOA    int `json:"doa"`
+}
+
+func (c *StaleCounts) add(si wire.StaleInfo) {
+	c.Shares++
+	switch si {
+	case wire.StaleInfoOrphan:
+		c.Orphan++
+	case wire.StaleInfoDOA:
+		c.DOA++
+	}
+}
+
+// prop returns stales as a fraction of all shares mined, the ones in the
+// chain and the stale ones announced by them.
+func (c StaleCounts) prop(stales int) float64 {
+	if c.Shares+c.Orphan+c.DOA == 0 {
+		return 0
+	}
+	return float64(stales) / float64(c.Shares+c.Orphan+c.DOA)
+}
+
+// StaleProp returns the fraction of the mined shares that went stale
+func (c StaleCounts) StaleProp() float64 {
+	return c.prop(c.Orphan + c.DOA)
+}
+
+// OrphanProp returns the fraction of the mined shares that were orphaned
+func (c StaleCounts) OrphanProp() float64 {
+	return c.prop(c.Orphan)
+}
+
+// DOAProp returns the fraction of the mined shares that were dead on arrival
+func (c StaleCounts) DOAProp() float64 {
+	return c.prop(c.DOA)
+}
+
+// GetStaleCounts adds up the StaleInfo of the last window shares up to the
+// tip, for the whole pool and per payout address.
+func (sc *ShareChain) GetStaleCounts(window int) (StaleCounts, map[string]StaleCounts) {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	pool := StaleCounts{}
+	perAddress := map[string]StaleCounts{}
+	s := sc.Tip
+	for i := 0; s != nil && i < window; i++ {
+		si := s.Share.ShareInfo.ShareData.StaleInfo
+		pool.add(si)
+		addr := ShareAddress(s.Share)
+		c := perAddress[addr]
+		c.add(si)
+		perAddress[addr] = c
+		s = s.Previous
+	}
+	return pool, perAddress
+}
+
+// OwnStaleCounts describes what became of the shares mined by this node
+type OwnStaleCounts struct {
+	// Shares is the number of shares mined since the node started
+	Shares int
+	// Orphan and DOA count the mined shares that are not in the chain
+	Orphan int
+	DOA    int
+	// OrphanRecorded and DOARecorded count the stales announced by our
+	// shares in the chain
+	OrphanRecorded int
+	DOARecorded    int
+}
+
+// ownShare is a share mined by this node
+type ownShare struct {
+	height int32
+	// doa is set when the work it was mined on was already outdated when it
+	// was submitted
+	doa bool
+}
+
+// AddOwnShare records that this node mined s. doa is set when the work it
+// was mined on was already outdated when it was submitted. Call it before
+// the share is added to the chain.
+func (sc *ShareChain) AddOwnShare(s *wire.Share, doa bool) {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+	sc.ownShares[s.Hash.String()] = ownShare{height: s.ShareInfo.AbsHeight, doa: doa}
+}
+
+// GetOwnStaleCounts compares the shares this node mined with the ones that
+// made it into the last chain length shares, like the reference
+// implementation does. Mined shares that are not in the chain went stale:
+// dead on arrival when they were marked so, orphaned otherwise. Own shares
+// deeper than the chain length are settled and only kept as counts.
+func (sc *ShareChain) GetOwnStaleCounts() OwnStaleCounts {
+	sc.allSharesLock.Lock()
+	defer sc.allSharesLock.Unlock()
+
+	sc.settleOwnShares()
+	c := sc.ownSettled
+	c.Shares += len(sc.ownShares)
+	doas := 0
+	for _, own := range sc.ownShares {
+		if own.doa {
+			doas++
+		}
+	}
+	inChain, doasInChain := 0, 0
+	s := sc.Tip
+	for i := 0; s != nil && i < sc.network.ChainLength; i++ {
+		if own, ok := sc.ownShares[s.Share.Hash.String()]; ok {
+			inChain++
+			if own.doa {
+				doasInChain++
+			}
+			c.addRecorded(s.Share.ShareInfo.ShareData.StaleInfo)
+		}
+		s = s.Previous
+	}
+	c.DOA += doas - doasInChain
+	c.Orphan += len(sc.ownShares) - inChain - (doas - doasInChain)
+	return c
+}
+
+func (c *OwnStaleCounts) addRecorded(si wire.StaleInfo) {
+	switch si {
+	case wire.StaleInfoOrphan:
+		c.OrphanRecorded++
+	case wire.StaleInfoDOA:
+		c.DOARecorded++
+	}
+}
+
+// settleOwnShares moves the own shares at or below the chain length from the
+// tip out of ownShares and into the ownSettled counts, as in the chain when
+// they are on the main chain and as stale otherwise. The caller must hold
+// allSharesLock.
+func (sc *ShareChain) settleOwnShares() {
+	if sc.Tip == nil {
+		return
+	}
+	bottom := sc.Tip.Share.ShareInfo.AbsHeight - int32(sc.network.ChainLength)
+	for hash, own := range sc.ownShares {
+		if own.height > bottom {
+			continue
+		}
+		delete(sc.ownShares, hash)
+		sc.ownSettled.Shares++
+		cs, ok := sc.AllShares[hash]
+		switch {
+		case ok && sc.inMainChain(cs):
+			sc.ownSettled.addRecorded(cs.Share.ShareInfo.ShareData.StaleInfo)
+		case own.doa:
+			sc.ownSettled.DOA++
+		default:
+			sc.ownSettled.Orphan++
+		}
+	}
+}
+
+// inMainChain returns whether cs is an ancestor of the tip. The Next links
+// of the main chain lead to the tip, those of forks end elsewhere. The caller
+// must hold allSharesLock.
+func (sc *ShareChain) inMainChain(cs *ChainShare) bool {
+	for ; cs != nil; cs = cs.Next {
+		if cs == sc.Tip {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStaleInfo returns the StaleInfo for the next share this node mines: it
+// announces one of our stale shares that no share in the chain announced
+// yet, orphans first.
+func (sc *ShareChain) NextStaleInfo() wire.StaleInfo {
+	c := sc.GetOwnStaleCounts()
+	switch {
+	case c.Orphan > c.OrphanRecorded:
+		return wire.StaleInfoOrphan
+	case c.DOA > c.DOARecorded:
+		return wire.StaleInfoDOA
+	}
+	return wire.StaleInfoNone
+}
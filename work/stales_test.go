@@ -0,0 +1,88 @@
+package work_test
+
+import (
+	"context"
+	"testing"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+func TestOwnStaleCounts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	sc := work.NewShareChain(ctx, n, t.TempDir())
+	defer func() {
+		cancel()
+		sc.Wait()
+	}()
+	tmpl := sim.NewFakeFullnode(0x200fffff, 50*100000000).BlockTemplate()
+
+	build := func(prev *wire.Share, si wire.StaleInfo) *wire.Share {
+		s, err := sim.BuildShare(n, prev, tmpl, make([]byte, 20), si)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &s
+	}
+	// mine adds a share this node mined to the chain
+	mine := func(prev *wire.Share, si wire.StaleInfo, doa bool) *wire.Share {
+		s := build(prev, si)
+		sc.AddOwnShare(s, doa)
+		sc.AddShares([]wire.Share{*s})
+		return s
+	}
+
+	// Our shares a1 and a2 make it into the chain, a2 although it was dead
+	// on arrival. Of the other two shares on a1 one lost against a2 and one
+	// was dead on arrival.
+	a0 := build(nil, wire.StaleInfoNone)
+	sc.AddShares([]wire.Share{*a0})
+	a1 := mine(a0, wire.StaleInfoNone, false)
+	a2 := mine(a1, wire.StaleInfoNone, true)
+	mine(a1, wire.StaleInfoNone, false)
+	mine(a1, wire.StaleInfoNone, true)
+
+	c := sc.GetOwnStaleCounts()
+	expected := work.OwnStaleCounts{Shares: 4, Orphan: 1, DOA: 1}
+	if c != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, c)
+	}
+	if si := sc.NextStaleInfo(); si != wire.StaleInfoOrphan {
+		t.Fatalf("Expected the next share to announce the orphan, got %d", si)
+	}
+
+	// The next shares announce both stales
+	a3 := mine(a2, wire.StaleInfoOrphan, false)
+	if si := sc.NextStaleInfo(); si != wire.StaleInfoDOA {
+		t.Fatalf("Expected the next share to announce the dead share, got %d", si)
+	}
+	tip := mine(a3, wire.StaleInfoDOA, false)
+	if !sc.GetTipHash().IsEqual(tip.Hash) {
+		t.Fatalf("Expected tip %s, got %s", tip.Hash, sc.GetTipHash())
+	}
+	c = sc.GetOwnStaleCounts()
+	expected = work.OwnStaleCounts{Shares: 6, Orphan: 1, DOA: 1, OrphanRecorded: 1, DOARecorded: 1}
+	if c != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, c)
+	}
+	if si := sc.NextStaleInfo(); si != wire.StaleInfoNone {
+		t.Fatalf("Expected nothing left to announce, got %d", si)
+	}
+
+	// Once the chain has grown past the chain length our shares are settled
+	// and still counted the same
+	for i := 0; i < n.ChainLength; i++ {
+		tip = build(tip, wire.StaleInfoNone)
+		sc.AddShares([]wire.Share{*tip})
+	}
+	c = sc.GetOwnStaleCounts()
+	if c != expected {
+		t.Fatalf("Expected %+v after settling, got %+v", expected, c)
+	}
+	if sc.OwnShareCount() != 0 {
+		t.Fatalf("Expected all own shares settled, %d left", sc.OwnShareCount())
+	}
+}
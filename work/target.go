@@ -0,0 +1,51 @@
+package work
+
+import (
+	"math/big"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// TargetToAverageAttempts returns the average number of hashes needed to
+// find a hash at or below target.
+func TargetToAverageAttempts(target *big.Int) *big.Int {
+	att := big.NewInt(1)
+	att.Lsh(att, 256)
+	return att.Div(att, big.NewInt(0).Add(target, big.NewInt(1)))
+}
+
+// TargetToDifficulty converts a target to a difficulty relative to the
+// difficulty 1 target of Bitcoin.
+func TargetToDifficulty(target *big.Int) float64 {
+	diff1 := big.NewInt(0xffff0000)
+	diff1.Lsh(diff1, 256-64)
+	diff1.Add(diff1, big.NewInt(1))
+	d, _ := new(big.Float).Quo(new(big.Float).SetInt(diff1), new(big.Float).SetInt(big.NewInt(0).Add(target, big.NewInt(1)))).Float64()
+	return d
+}
+
+// absWorkModulus is the range of the 128 bit AbsWork field of shares
+var absWorkModulus = big.NewInt(0).Lsh(big.NewInt(1), 128)
+
+// ShareWork returns the work a share adds to the chain: the average number of
+// hashes needed to meet its target.
+func ShareWork(s *wire.Share) *big.Int {
+	return TargetToAverageAttempts(blockchain.CompactToBig(uint32(s.ShareInfo.Bits)))
+}
+
+// ExpectedAbsWork returns the absolute work a share built on prev must
+// carry.
+func ExpectedAbsWork(prev *wire.Share, s *wire.Share) *big.Int {
+	w := big.NewInt(0).Add(prev.ShareInfo.AbsWork, ShareWork(s))
+	return w.Mod(w, absWorkModulus)
+}
+
+// MoreWork returns true when absolute work a is more than b. AbsWork wraps
+// around at 2^128, so the values are compared by their distance, which is
+// far less than 2^127 for any two shares on the same network.
+func MoreWork(a, b *big.Int) bool {
+	d := big.NewInt(0).Sub(a, b)
+	d.Mod(d, absWorkModulus)
+	return d.Sign() > 0 && d.Cmp(big.NewInt(0).Rsh(absWorkModulus, 1)) < 0
+}
@@ -0,0 +1,111 @@
+package work
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/wire"
+)
+
+// ShareError describes why a share failed verification
+type ShareError struct {
+	Hash   *chainhash.Hash
+	Reason string
+}
+
+func (e ShareError) Error() string {
+	return fmt.Sprintf("Share %s: %s", e.Hash.String(), e.Reason)
+}
+
+// VerifyShare checks a single share on its own: its hashes are recalculated
+// from the serialized fields, its proof of work must meet its target and its
+// target must be within the limits of network n.
+func VerifyShare(n p2pnet.Network, s *wire.Share) error {
+	fail := func(format string, args ...interface{}) error {
+		return ShareError{Hash: s.Hash, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if s.Type < n.MinShareVersion {
+		return fail("version %d is below the minimum version %d", s.Type, n.MinShareVersion)
+	}
+
+	c := *s
+	err := c.CalcHashes(n)
+	if err != nil {
+		return fail("could not calculate hashes: %s", err.Error())
+	}
+	if s.Hash == nil || !c.Hash.IsEqual(s.Hash) {
+		return fail("hash does not match its contents, calculated %s", c.Hash.String())
+	}
+	if s.POWHash == nil || !c.POWHash.IsEqual(s.POWHash) {
+		return fail("proof of work hash does not match its contents")
+	}
+
+	if !s.IsValid() {
+		return fail("proof of work does not meet the share target")
+	}
+	target := blockchain.CompactToBig(uint32(s.ShareInfo.Bits))
+	maxTarget := blockchain.CompactToBig(uint32(s.ShareInfo.MaxBits))
+	if target.Cmp(maxTarget) > 0 {
+		return fail("share target is above its max target")
+	}
+	if n.MaxTarget != nil && maxTarget.Cmp(n.MaxTarget) > 0 {
+		return fail("max target is above the network maximum")
+	}
+	return nil
+}
+
+// VerifyShares checks every share with VerifyShare, and that each share
+// connects to its predecessor: the previous share has to be in shares, except
+// for the oldest share, the absolute height has to increase by one and the
+// absolute work by the work of the share. It returns all problems found.
+func VerifyShares(n p2pnet.Network, shares []wire.Share) []error {
+	errs := make([]error, 0)
+	byHash := make(map[chainhash.Hash]*wire.Share, len(shares))
+	for i := range shares {
+		if shares[i].Hash != nil {
+			byHash[*shares[i].Hash] = &shares[i]
+		}
+	}
+
+	unconnected := 0
+	for i := range shares {
+		s := &shares[i]
+		err := VerifyShare(n, s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		prevHash := s.ShareInfo.ShareData.PreviousShareHash
+		prev, ok := byHash[*orNull(prevHash)]
+		if !ok {
+			if !isNullHash(prevHash) {
+				unconnected++
+			}
+			continue
+		}
+		if s.ShareInfo.AbsHeight != prev.ShareInfo.AbsHeight+1 {
+			errs = append(errs, ShareError{Hash: s.Hash, Reason: fmt.Sprintf("absolute height %d does not follow %d of the previous share", s.ShareInfo.AbsHeight, prev.ShareInfo.AbsHeight)})
+		}
+		if expected := ExpectedAbsWork(prev, s); s.ShareInfo.AbsWork == nil || expected.Cmp(s.ShareInfo.AbsWork) != 0 {
+			errs = append(errs, ShareError{Hash: s.Hash, Reason: fmt.Sprintf("absolute work %s does not follow the previous share, expected %s", s.ShareInfo.AbsWork, expected)})
+		}
+	}
+
+	// The oldest share of a chain that has been cut off at ChainLength
+	// refers to a share that is no longer kept
+	if unconnected > 1 {
+		errs = append(errs, fmt.Errorf("%d shares do not connect to a previous share", unconnected))
+	}
+	return errs
+}
+
+func orNull(h *chainhash.Hash) *chainhash.Hash {
+	if h == nil {
+		return &chainhash.Hash{}
+	}
+	return h
+}
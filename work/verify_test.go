@@ -0,0 +1,139 @@
+package work_test
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	p2pnet "github.com/gertjaap/p2pool-go/net"
+	"github.com/gertjaap/p2pool-go/sim"
+	"github.com/gertjaap/p2pool-go/wire"
+	"github.com/gertjaap/p2pool-go/work"
+)
+
+// buildChain builds count shares, each on the previous one, on top of prev
+func buildChain(t *testing.T, n p2pnet.Network, prev *wire.Share, count int) []wire.Share {
+	tmpl := sim.NewFakeFullnode(0x200fffff, 50*100000000).BlockTemplate()
+	shares := make([]wire.Share, 0, count)
+	for i := 0; i < count; i++ {
+		s, err := sim.BuildShare(n, prev, tmpl, make([]byte, 20), wire.StaleInfoNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares = append(shares, s)
+		prev = &shares[len(shares)-1]
+	}
+	return shares
+}
+
+// withAbsWork returns a copy of s claiming absolute work w, with its hashes
+// recalculated and its proof of work redone so only the work is wrong
+func withAbsWork(t *testing.T, n p2pnet.Network, s wire.Share, w *big.Int) wire.Share {
+	s.ShareInfo.AbsWork = w
+	for nonce := uint32(0); ; nonce++ {
+		s.MinHeader.Nonce = nonce
+		err := s.CalcHashes(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.IsValid() {
+			return s
+		}
+	}
+}
+
+func TestVerifySharesAbsWork(t *testing.T) {
+	n := p2pnet.Regtest()
+	shares := buildChain(t, n, nil, 3)
+	if errs := work.VerifyShares(n, shares); len(errs) != 0 {
+		t.Fatalf("Expected a valid chain, got %v", errs)
+	}
+
+	w := big.NewInt(0).Add(shares[2].ShareInfo.AbsWork, big.NewInt(1))
+	shares[2] = withAbsWork(t, n, shares[2], w)
+	errs := work.VerifyShares(n, shares)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "absolute work") {
+		t.Fatalf("Expected an absolute work error, got %v", errs)
+	}
+}
+
+func TestResolveRejectsAbsWork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	sc := work.NewShareChain(ctx, n, t.TempDir())
+	defer func() {
+		cancel()
+		sc.Wait()
+	}()
+
+	shares := buildChain(t, n, nil, 2)
+	sc.AddShares(shares)
+
+	// A share claiming more work than it adds would take over the tip
+	next := buildChain(t, n, &shares[1], 1)[0]
+	inflated := big.NewInt(0).Lsh(next.ShareInfo.AbsWork, 1)
+	bad := withAbsWork(t, n, next, inflated)
+	sc.AddShares([]wire.Share{bad})
+	if sc.GetShare(bad.Hash) != nil {
+		t.Fatal("Expected the share with the wrong absolute work to be ignored")
+	}
+	if !sc.GetTipHash().IsEqual(shares[1].Hash) {
+		t.Fatalf("Expected tip %s, got %s", shares[1].Hash, sc.GetTipHash())
+	}
+
+	sc.AddShares([]wire.Share{next})
+	if !sc.GetTipHash().IsEqual(next.Hash) {
+		t.Fatalf("Expected tip %s, got %s", next.Hash, sc.GetTipHash())
+	}
+}
+
+// mainChain returns the hashes from the tail of sc forward along the Next
+// links
+func mainChain(sc *work.ShareChain) []string {
+	hashes := make([]string, 0)
+	for c := sc.Tail; c != nil; c = c.Next {
+		hashes = append(hashes, c.Share.Hash.String())
+	}
+	return hashes
+}
+
+func expectMainChain(t *testing.T, sc *work.ShareChain, shares ...wire.Share) {
+	t.Helper()
+	got := mainChain(sc)
+	if len(got) != len(shares) {
+		t.Fatalf("Expected %d shares on the main chain, got %d", len(shares), len(got))
+	}
+	for i := range shares {
+		if got[i] != shares[i].Hash.String() {
+			t.Fatalf("Expected share %d on the main chain to be %s, got %s", i, shares[i].Hash, got[i])
+		}
+	}
+	if !sc.GetTipHash().IsEqual(shares[len(shares)-1].Hash) {
+		t.Fatalf("Expected tip %s, got %s", shares[len(shares)-1].Hash, sc.GetTipHash())
+	}
+}
+
+func TestSetTipReorg(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := p2pnet.Regtest()
+	sc := work.NewShareChain(ctx, n, t.TempDir())
+	defer func() {
+		cancel()
+		sc.Wait()
+	}()
+
+	a := buildChain(t, n, nil, 3)
+	sc.AddShares(a)
+	expectMainChain(t, sc, a...)
+
+	// A longer fork from a[0] takes over, and walking forward follows it
+	b := buildChain(t, n, &a[0], 3)
+	sc.AddShares(b)
+	expectMainChain(t, sc, a[0], b[0], b[1], b[2])
+
+	// Extending the old branch past the fork switches back
+	c := buildChain(t, n, &a[2], 2)
+	sc.AddShares(c)
+	expectMainChain(t, sc, a[0], a[1], a[2], c[0], c[1])
+}